@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const authTokenCommandTimeout = time.Minute
+
+// authTokenCommand gets a bearer token from the output of a command, e.g.
+// az account get-access-token. The command is run directly, not by a shell.
+type authTokenCommand struct {
+	Command []string `yaml:"Command"`
+
+	// JSONPath is the dot separated path to the token in the JSON output,
+	// e.g. accessToken or data.tokens.0. The whole output is used if empty.
+	JSONPath string `yaml:"JSONPath"`
+
+	// RefreshInterval is how often the command is run again, defaults to 0
+	// (never).
+	RefreshInterval time.Duration `yaml:"RefreshInterval"`
+
+	token atomic.Value // string
+}
+
+// start gets the first token and refreshes it every RefreshInterval.
+func (a *authTokenCommand) start() error {
+	if len(a.Command) == 0 {
+		return errors.New("AuthTokenCommand: Command must be specified")
+	}
+
+	token, err := a.run()
+	if err != nil {
+		return err
+	}
+	a.token.Store(token)
+
+	if a.RefreshInterval > 0 {
+		go func() {
+			for range time.Tick(a.RefreshInterval) {
+				token, err := a.run()
+				if err != nil {
+					// Keep using the current token, it may still be valid
+					log.Println("Failure refreshing auth token:", err)
+					continue
+				}
+				a.token.Store(token)
+			}
+		}()
+	}
+
+	return nil
+}
+
+// header returns the value of the Authorization header.
+func (a *authTokenCommand) header() string {
+	return "Bearer " + a.token.Load().(string)
+}
+
+func (a *authTokenCommand) run() (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), authTokenCommandTimeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	// #nosec the command comes from the config, it's not a shell command line
+	cmd := exec.CommandContext(ctx, a.Command[0], a.Command[1:]...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("AuthTokenCommand %s failed: %v: %s", a.Command[0], err, strings.TrimSpace(stderr.String()))
+	}
+
+	token := strings.TrimSpace(stdout.String())
+	if a.JSONPath != "" {
+		var err error
+		if token, err = tokenFromJSON(stdout.Bytes(), a.JSONPath); err != nil {
+			return "", fmt.Errorf("AuthTokenCommand %s: %v", a.Command[0], err)
+		}
+	}
+
+	if token == "" {
+		return "", fmt.Errorf("AuthTokenCommand %s returned an empty token", a.Command[0])
+	}
+	return token, nil
+}
+
+// tokenFromJSON returns the string at the dot separated path, where numbers
+// index arrays.
+func tokenFromJSON(output []byte, path string) (string, error) {
+	var value interface{}
+	if err := json.Unmarshal(output, &value); err != nil {
+		return "", fmt.Errorf("output is not JSON: %v", err)
+	}
+
+	for _, key := range strings.Split(path, ".") {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			var ok bool
+			if value, ok = v[key]; !ok {
+				return "", fmt.Errorf("no %s in the output", path)
+			}
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(v) {
+				return "", fmt.Errorf("no %s in the output", path)
+			}
+			value = v[i]
+		default:
+			return "", fmt.Errorf("no %s in the output", path)
+		}
+	}
+
+	token, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("%s in the output is not a string", path)
+	}
+	return token, nil
+}
@@ -0,0 +1,17 @@
+package bench
+
+import (
+	"runtime"
+
+	"golang.org/x/sys/unix"
+)
+
+// pinToCPU locks the calling goroutine to its OS thread and restricts that
+// thread to run on the given CPU only.
+func pinToCPU(cpu int) error {
+	runtime.LockOSThread()
+
+	var set unix.CPUSet
+	set.Set(cpu)
+	return unix.SchedSetaffinity(0, &set)
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+// +build !linux
+
+package bench
+
+// pinToCPU is a no-op on platforms which don't support thread affinity.
+func pinToCPU(cpu int) error {
+	return nil
+}
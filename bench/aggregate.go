@@ -0,0 +1,188 @@
+package bench
+
+import (
+	"bytes"
+	"math"
+	"strconv"
+
+	"github.com/codahale/hdrhistogram"
+	"github.com/olekukonko/tablewriter"
+)
+
+// Aggregate combines Summaries of repeated runs of the same Benchmark into a
+// single Summary with merged totals and histogram.
+func Aggregate(summaries []*Summary) *Summary {
+	if len(summaries) == 0 {
+		return nil
+	}
+
+	first := summaries[0]
+	aggregate := &Summary{
+		Connections:       first.Connections,
+		RequestRate:       first.RequestRate,
+		PerConnectionRate: first.PerConnectionRate,
+		SuccessHistogram:  hdrhistogram.Import(first.SuccessHistogram.Export()),
+		Errors:            make(map[string]int),
+		HeadlineMetric:    first.HeadlineMetric,
+		OutputJson:        first.OutputJson,
+	}
+	aggregate.SuccessHistogram.Reset()
+	if first.RequestSizes != nil {
+		aggregate.RequestSizes = hdrhistogram.Import(first.RequestSizes.Export())
+		aggregate.RequestSizes.Reset()
+	}
+	if first.SchedulingDelay != nil {
+		aggregate.SchedulingDelay = hdrhistogram.Import(first.SchedulingDelay.Export())
+		aggregate.SchedulingDelay.Reset()
+	}
+	if first.CorrectedHistogram != nil {
+		aggregate.CorrectedHistogram = hdrhistogram.Import(first.CorrectedHistogram.Export())
+		aggregate.CorrectedHistogram.Reset()
+	}
+	if first.SendDelayHistogram != nil {
+		aggregate.SendDelayHistogram = hdrhistogram.Import(first.SendDelayHistogram.Export())
+		aggregate.SendDelayHistogram.Reset()
+	}
+
+	for _, p := range first.Phases {
+		aggregate.Phases = append(aggregate.Phases, &PhaseSummary{
+			Name:             p.Name,
+			SuccessHistogram: hdrhistogram.New(p.SuccessHistogram.LowestTrackableValue(), p.SuccessHistogram.HighestTrackableValue(), int(p.SuccessHistogram.SignificantFigures())),
+		})
+	}
+
+	var requestTotal uint64
+	for _, s := range summaries {
+		aggregate.SuccessTotal += s.SuccessTotal
+		aggregate.ErrorTotal += s.ErrorTotal
+		aggregate.SlowTotal += s.SlowTotal
+		aggregate.DroppedTotal += s.DroppedTotal
+		aggregate.RateLimited += s.RateLimited
+		aggregate.TimeElapsed += s.TimeElapsed
+		aggregate.SuccessHistogram.Merge(s.SuccessHistogram)
+		if aggregate.RequestSizes != nil && s.RequestSizes != nil {
+			aggregate.RequestSizes.Merge(s.RequestSizes)
+		}
+		if aggregate.SchedulingDelay != nil && s.SchedulingDelay != nil {
+			aggregate.SchedulingDelay.Merge(s.SchedulingDelay)
+		}
+		if aggregate.CorrectedHistogram != nil && s.CorrectedHistogram != nil {
+			aggregate.CorrectedHistogram.Merge(s.CorrectedHistogram)
+		}
+		if aggregate.SendDelayHistogram != nil && s.SendDelayHistogram != nil {
+			aggregate.SendDelayHistogram.Merge(s.SendDelayHistogram)
+		}
+		aggregate.AvgRequestTime += s.AvgRequestTime * float64(s.SuccessTotal)
+		aggregate.GCCount += s.GCCount
+		aggregate.GCPauseTotal += s.GCPauseTotal
+		if s.GCPauseMax > aggregate.GCPauseMax {
+			aggregate.GCPauseMax = s.GCPauseMax
+		}
+		aggregate.TicksTimely += s.TicksTimely
+		aggregate.SendsTimely += s.SendsTimely
+		for errorText, count := range s.Errors {
+			aggregate.Errors[errorText] += count
+		}
+		for i, p := range s.Phases {
+			if i < len(aggregate.Phases) {
+				aggregate.Phases[i].Duration += p.Duration
+				aggregate.Phases[i].SuccessTotal += p.SuccessTotal
+				aggregate.Phases[i].ErrorTotal += p.ErrorTotal
+				aggregate.Phases[i].SuccessHistogram.Merge(p.SuccessHistogram)
+			}
+		}
+
+		// Timeliness ratios are weighted by the number of requests in each run
+		runTotal := s.requestTotal()
+		requestTotal += runTotal
+		aggregate.TicksTimelyRatio += s.TicksTimelyRatio * float64(runTotal)
+		aggregate.SendsTimelyRatio += s.SendsTimelyRatio * float64(runTotal)
+	}
+
+	if aggregate.SuccessTotal > 0 {
+		aggregate.AvgRequestTime /= float64(aggregate.SuccessTotal)
+	}
+	if requestTotal > 0 {
+		aggregate.TicksTimelyRatio /= float64(requestTotal)
+		aggregate.SendsTimelyRatio /= float64(requestTotal)
+	}
+	if aggregate.TimeElapsed > 0 {
+		aggregate.Throughput = float64(requestTotal) / aggregate.TimeElapsed.Seconds()
+	}
+
+	return aggregate
+}
+
+// RepeatsTable returns a table with the key metrics of every run, along with
+// their mean and standard deviation across runs.
+func RepeatsTable(summaries []*Summary) string {
+	metrics := []struct {
+		name  string
+		value func(s *Summary) float64
+	}{
+		{"Success %", func(s *Summary) float64 { return s.successRate() }},
+		{"Throughput (req/sec)", func(s *Summary) float64 { return s.Throughput }},
+		{"AvgRequestTime (ms)", func(s *Summary) float64 { return s.AvgRequestTime }},
+		{"P50 (ms)", func(s *Summary) float64 { return float64(s.SuccessHistogram.ValueAtQuantile(50)) / 1000000 }},
+		{"P99 (ms)", func(s *Summary) float64 { return float64(s.SuccessHistogram.ValueAtQuantile(99)) / 1000000 }},
+		{"P99.9 (ms)", func(s *Summary) float64 { return float64(s.SuccessHistogram.ValueAtQuantile(99.9)) / 1000000 }},
+	}
+
+	var outputBuffer bytes.Buffer
+	table := tablewriter.NewWriter(&outputBuffer)
+
+	header := []string{"Run"}
+	for _, m := range metrics {
+		header = append(header, m.name)
+	}
+	table.SetHeader(header)
+
+	values := make([][]float64, len(metrics))
+	for i, s := range summaries {
+		row := []string{strconv.Itoa(i + 1)}
+		for j, m := range metrics {
+			v := m.value(s)
+			values[j] = append(values[j], v)
+			row = append(row, strconv.FormatFloat(v, 'f', 2, 64))
+		}
+		table.Append(row)
+	}
+
+	meanRow := []string{"Mean"}
+	stdDevRow := []string{"StdDev"}
+	for j := range metrics {
+		mean, stdDev := meanAndStdDev(values[j])
+		meanRow = append(meanRow, strconv.FormatFloat(mean, 'f', 2, 64))
+		stdDevRow = append(stdDevRow, strconv.FormatFloat(stdDev, 'f', 2, 64))
+	}
+	table.Append(meanRow)
+	table.Append(stdDevRow)
+
+	outputBuffer.WriteString("\n")
+	table.Render()
+	return outputBuffer.String()
+}
+
+func meanAndStdDev(values []float64) (float64, float64) {
+	if len(values) == 0 {
+		return 0, 0
+	}
+
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	mean := sum / float64(len(values))
+
+	if len(values) == 1 {
+		return mean, 0
+	}
+
+	var squares float64
+	for _, v := range values {
+		squares += (v - mean) * (v - mean)
+	}
+
+	// Sample standard deviation
+	return mean, math.Sqrt(squares / float64(len(values)-1))
+}
@@ -4,6 +4,7 @@ Package bench provides a generic framework for performing latency benchmarks.
 package bench
 
 import (
+	"errors"
 	"regexp"
 	"sync"
 	"sync/atomic"
@@ -13,14 +14,27 @@ import (
 	"log"
 
 	"github.com/codahale/hdrhistogram"
+	"golang.org/x/time/rate"
 )
 
 const (
 	minRecordableLatencyNS = 1000000
 	maxRecordableLatencyNS = 100000000000
 	sigFigs                = 5
+
+	// Send delays are much shorter than latencies and need better resolution
+	minRecordableSendDelayNS = 1000
+	sendDelaySigFigs         = 3
+
+	maxRecordableRequestSize = 1 << 36
+	requestSizeSigFigs       = 3
 )
 
+// ErrSlow is returned (possibly wrapped) by a Requester which cancelled a
+// request for taking too long. Such requests are counted as slow, neither as
+// successful nor as failed.
+var ErrSlow = errors.New("slow request cancelled")
+
 // RequesterFactory creates new Requesters.
 type RequesterFactory interface {
 	// GetRequester returns a new Requester, called for each Benchmark
@@ -40,26 +54,119 @@ type Requester interface {
 	Teardown() error
 }
 
+// LatencyMeasurer is optionally implemented by Requesters which measure the
+// latency themselves, e.g. as time to first byte. It's recorded instead of the
+// duration of Request.
+type LatencyMeasurer interface {
+	// MeasuredLatency returns the latency of the last request, or false if
+	// it wasn't measured.
+	MeasuredLatency() (time.Duration, bool)
+}
+
+// RequestSizer is optionally implemented by Requesters sending bodies of
+// varying size. The sizes are recorded in the Summary.
+type RequestSizer interface {
+	// RequestSize returns the body size in bytes of the last request, or
+	// false if it's not tracked.
+	RequestSize() (int64, bool)
+}
+
+// RequestCanceler is optionally implemented by Requesters which can abandon
+// their request in flight. It's called from another goroutine than Request
+// once the benchmark stopped waiting for the request, e.g. after
+// DrainTimeout, whose result is then dropped anyway.
+type RequestCanceler interface {
+	// CancelRequest makes the request in flight, if any, return early.
+	CancelRequest()
+}
+
 // Benchmark performs a system benchmark by attempting to issue requests at a
 // specified rate and capturing the latency distribution. The request rate is
 // divided across the number of configured connections.
 type Benchmark struct {
-	connections      uint64
-	requestRate      float64
-	duration         time.Duration
-	baseLatency      time.Duration
-	expectedInterval time.Duration
-	successHistogram *hdrhistogram.Histogram
-	successTotal     uint64
-	errorTotal       uint64
-	avgRequestTime   float64
-	elapsed          time.Duration
-	factory          RequesterFactory
-	timelyTicks      uint64
-	missedTicks      uint64
-	timelySends      uint64
-	lateSends        uint64
-	errors           map[string]int
+	// ReportInterval is the length of the interval over which IntervalStats
+	// are collected and passed to IntervalReporters. Defaults to one second.
+	ReportInterval time.Duration
+
+	// IntervalReporters receive the results of every reporting interval.
+	IntervalReporters []IntervalReporter
+
+	// DrainTimeout bounds how long to wait for in-flight requests after the
+	// benchmark duration is over. Requests which don't complete in time are
+	// counted as dropped at shutdown. Zero waits for all of them.
+	DrainTimeout time.Duration
+
+	// WorkerCPUs lists the CPUs the workers are pinned to in round-robin
+	// fashion, leaving the remaining CPUs to the ticker, collector and GC.
+	// Each worker then occupies its own OS thread. Only supported on Linux.
+	WorkerCPUs []int
+
+	// PerConnectionRate gives every connection its own evenly spaced share of
+	// the ticks (round-robin) instead of handing each tick to any idle
+	// connection, so each connection issues exactly requestRate/connections
+	// requests per second. A tick is missed if its connection is still busy.
+	PerConnectionRate bool
+
+	// Warmup is run before the measurement starts, at the same request rate.
+	// Results of requests sent during Warmup are discarded.
+	Warmup time.Duration
+
+	// GCInterval forces a garbage collection in the benchmark process at this
+	// interval during Warmup, so the heap is in a steady state once the
+	// measurement starts. GC is never forced during the measurement.
+	GCInterval time.Duration
+
+	// Phases split the duration into labeled parts reported separately. They
+	// must add up to the duration.
+	Phases []Phase
+
+	// RateLimiter caps the rate of requests sent, it can be shared by several
+	// Benchmarks to cap their combined rate. Like missed ticks, ticks over the
+	// limit are skipped rather than delayed.
+	RateLimiter *rate.Limiter
+
+	// UntilStable stops the benchmark once the given latency percentile is
+	// stable, the duration is then the maximum run time.
+	UntilStable *StabilityCriterion
+
+	// HistogramMin is the lowest latency the histograms discern, latencies
+	// below it are all recorded in the bottom bucket. Defaults to 1ms, lower it
+	// to e.g. 1µs for sub-millisecond endpoints, at the cost of memory.
+	HistogramMin time.Duration
+
+	// HeadlineMetric selects the latency shown in the one-line summary: mean
+	// (default), median or a percentile like p99.
+	HeadlineMetric string
+
+	connections        uint64
+	requestRate        float64
+	duration           time.Duration
+	baseLatency        time.Duration
+	expectedInterval   time.Duration
+	successHistogram   *hdrhistogram.Histogram
+	correctedHistogram *hdrhistogram.Histogram
+	sendDelayHistogram *hdrhistogram.Histogram
+	successTotal       uint64
+	errorTotal         uint64
+	slowTotal          uint64
+	droppedTotal       uint64
+	rateLimited        uint64
+	sendMu             sync.RWMutex // held for reading while a request starts, see stopSends
+	sendsStopped       bool
+	sent               uint64 // requests started, warmup included
+	avgRequestTime     float64
+	elapsed            time.Duration
+	factory            RequesterFactory
+	timelyTicks        uint64
+	missedTicks        uint64
+	timelySends        uint64
+	lateSends          uint64
+	errors             map[string]int
+	measureStart       time.Time // set by the ticker before the first tick
+	gcStats            gcStats
+	phases             []*PhaseSummary
+	schedulingDelay    *hdrhistogram.Histogram
+	requestSizes       *hdrhistogram.Histogram
 }
 
 // NewBenchmark creates a Benchmark which runs a system benchmark using the
@@ -80,59 +187,127 @@ func NewBenchmark(factory RequesterFactory, requestRate, connections uint64, dur
 	}
 
 	return &Benchmark{
-		connections:      connections,
-		requestRate:      float64(requestRate),
-		duration:         duration,
-		baseLatency:      baseLatency,
-		expectedInterval: time.Duration(float64(time.Second) / float64(requestRate)),
-		successHistogram: hdrhistogram.New(minRecordableLatencyNS, maxRecordableLatencyNS, sigFigs),
-		factory:          factory,
-		errors:           make(map[string]int)}
+		connections:        connections,
+		requestRate:        float64(requestRate),
+		duration:           duration,
+		baseLatency:        baseLatency,
+		expectedInterval:   time.Duration(float64(time.Second) / float64(requestRate)),
+		sendDelayHistogram: hdrhistogram.New(minRecordableSendDelayNS, maxRecordableLatencyNS, sendDelaySigFigs),
+		factory:            factory,
+		errors:             make(map[string]int)}
 }
 
 // Run the benchmark and return a summary of the results. An error is returned
 // if something went wrong along the way.
 func (b *Benchmark) Run(outputJson bool, forceTightTicker bool) (*Summary, error) {
+	minLatency := b.histogramMin()
+	// hdrhistogram needs the highest trackable value to be at least twice the lowest
+	if minLatency < 1 || minLatency*2 > maxRecordableLatencyNS {
+		return nil, fmt.Errorf("HistogramMin must be between 1ns and %v", time.Duration(maxRecordableLatencyNS/2))
+	}
+	if c := b.UntilStable; c != nil && (c.Percentile <= 0 || c.Percentile > 100 || c.MaxWidthPercent <= 0) {
+		return nil, errors.New("UntilStable needs Percentile between 0 and 100 and a positive MaxWidthPercent")
+	}
+	if len(b.Phases) > 0 {
+		var total time.Duration
+		for _, phase := range b.Phases {
+			total += phase.Duration
+		}
+		if total != b.duration {
+			return nil, fmt.Errorf("Phases add up to %v, which is not the duration %v", total, b.duration)
+		}
+	}
+	b.successHistogram = hdrhistogram.New(minLatency, maxRecordableLatencyNS, sigFigs)
+	b.phases = newPhaseSummaries(b.Phases, minLatency)
+	b.requestSizes = hdrhistogram.New(1, maxRecordableRequestSize, requestSizeSigFigs)
+	b.correctedHistogram = hdrhistogram.New(minLatency, maxRecordableLatencyNS, sigFigs)
+
+	b.sendsStopped = false
+	b.sent = 0
+
+	// Measured while nothing runs yet
+	lateness := idleTimerLateness()
+
 	var (
-		ticker        = make(chan time.Time)
-		results       = make(chan int64, 100)
-		errors        = make(chan error, 100)
+		ticks         = newTickDispatcher(b.connections, b.PerConnectionRate)
+		results       = make(chan result, 200)
 		done          = make(chan struct{})
+		measuring     = make(chan struct{})
+		stable        = make(chan struct{})
+		gcDone        = make(chan struct{})
+		watchdogDone  = make(chan struct{})
 		stopCollector = make(chan struct{})
+		collectorDone = make(chan struct{})
+		workersDone   = make(chan struct{})
 		wg            sync.WaitGroup
 	)
 
 	// Prepare connection benchmarks
+	requesters := make([]Requester, b.connections)
+	for i := range requesters {
+		requesters[i] = b.factory.GetRequester(uint64(i))
+	}
 	wg.Add(int(b.connections))
 	for i := uint64(0); i < b.connections; i++ {
 		i := i
 		go func() {
-			b.worker(b.factory.GetRequester(i), ticker, results, errors)
+			if len(b.WorkerCPUs) > 0 {
+				maybePanic(pinToCPU(b.WorkerCPUs[i%uint64(len(b.WorkerCPUs))]))
+			}
+			b.worker(requesters[i], ticks.channel(i), results, stopCollector)
 			// log.Printf("Worker %d done\n", i)
 			wg.Done()
 		}()
 	}
 
+	go func() {
+		wg.Wait()
+		close(workersDone)
+	}()
+
 	// Prepare ticker
-	go b.tickerFunc(done, ticker, forceTightTicker)
+	go b.tickerFunc(done, measuring, stable, ticks, forceTightTicker)
 
 	// Prepare results collector
 	go func() {
-		b.collectorFunc(stopCollector, results, errors)
+		b.collectorFunc(stopCollector, measuring, stable, results)
 		// log.Println("Collector done")
-		wg.Done()
+		close(collectorDone)
+	}()
+
+	go func() {
+		b.gcFunc(measuring)
+		close(gcDone)
+	}()
+
+	go func() {
+		b.watchdogFunc(lateness, measuring, done)
+		close(watchdogDone)
 	}()
 
 	// Wait for completion of workers
-	wg.Wait()
+	b.waitForWorkers(done, workersDone)
 	// log.Println("Workers have finished")
 
-	wg.Add(1)
+	<-gcDone
+	b.gcStats.finish()
+	<-watchdogDone
+
 	close(stopCollector)
-	wg.Wait()
+	<-collectorDone
 
 	// log.Println("Collector has finished")
 
+	if !isClosed(workersDone) {
+		// The requests still in flight were dropped, their workers are left
+		// to finish on their own
+		cancelRequests(requesters)
+	}
+
+	if b.droppedTotal > 0 {
+		fmt.Printf("Dropped at shutdown = %d requests still in flight after DrainTimeout = %v\n", b.droppedTotal, b.DrainTimeout)
+	}
+
 	fmt.Printf("Ticks=%d, TimelyTicks = %d, MissedTicks = %d, %.2f%% good\n", b.timelyTicks+b.missedTicks, b.timelyTicks, b.missedTicks, float64(b.timelyTicks)*100/float64(b.timelyTicks+b.missedTicks))
 	fmt.Printf("Sends=%d, TimelySends = %d, LateSends   = %d, %.2f%% good\n", b.timelySends+b.lateSends, b.timelySends, b.lateSends, float64(b.timelySends)*100/float64(b.timelySends+b.lateSends))
 
@@ -149,22 +324,175 @@ func (b *Benchmark) Run(outputJson bool, forceTightTicker bool) (*Summary, error
 	return summary, nil
 }
 
-func (b *Benchmark) collectorFunc(doneCh <-chan struct{}, results <-chan int64, errors <-chan error) {
+// histogramMin returns the lowest latency in ns the histograms discern.
+func (b *Benchmark) histogramMin() int64 {
+	if b.HistogramMin <= 0 {
+		return minRecordableLatencyNS
+	}
+	return b.HistogramMin.Nanoseconds()
+}
+
+// waitForWorkers waits until all workers finish, or until DrainTimeout
+// expires after the ticker is done.
+func (b *Benchmark) waitForWorkers(tickerDone <-chan struct{}, workersDone <-chan struct{}) {
+	if b.DrainTimeout <= 0 {
+		<-workersDone
+		return
+	}
+
+	select {
+	case <-workersDone:
+		return
+	case <-tickerDone:
+	}
+
+	drainTimer := time.NewTimer(b.DrainTimeout)
+	defer drainTimer.Stop()
+
+	select {
+	case <-workersDone:
+	case <-drainTimer.C:
+	}
+}
+
+// result of a single request, passed from a worker to the collector.
+type result struct {
+	latency   int64
+	sendDelay int64 // time between the tick and the actual start of the request
+	err       error
+	warmup    bool  // sent during Warmup, not recorded
+	phase     int   // index of the Phase the request was sent in, -1 if none
+	size      int64 // request body size, -1 if not tracked
+}
+
+func (b *Benchmark) collectorFunc(doneCh <-chan struct{}, measuring <-chan struct{}, stable chan<- struct{}, results <-chan result) {
 	var (
 		baseLatency    = b.baseLatency.Nanoseconds()
 		successTotal   int64
+		errorTotal     uint64
+		slowTotal      uint64
+		receivedTotal  uint64  // results of all requests, warmup included
 		avgRequestTime float64 // Average latency for processing requests
+		interval       *intervalCollector
+		intervalTicks  <-chan time.Time
+		stability      *stabilityTracker
+		stabilityTicks <-chan time.Time
 	)
+
+	// Every connection is expected to send a request this often, requests it
+	// couldn't send while waiting for a slow response are accounted for in the
+	// corrected histogram
+	connectionInterval := b.expectedInterval.Nanoseconds() * int64(b.connections)
+
+	if len(b.IntervalReporters) > 0 {
+		reportInterval := b.ReportInterval
+		if reportInterval <= 0 {
+			reportInterval = time.Second
+		}
+		intervalTicker := time.NewTicker(reportInterval)
+		defer intervalTicker.Stop()
+		intervalTicks = intervalTicker.C
+		interval = newIntervalCollector(b.IntervalReporters, b.histogramMin())
+	}
+
+	if b.UntilStable != nil {
+		stability = newStabilityTracker(*b.UntilStable, b.histogramMin())
+		stabilityTicker := time.NewTicker(stability.criterion.SampleInterval)
+		defer stabilityTicker.Stop()
+		stabilityTicks = stabilityTicker.C
+	}
+
+	recordSuccess := func(sample int64, phase int) {
+		successTotal++
+		maybePanic(b.successHistogram.RecordValue(sample - baseLatency))
+		maybePanic(b.correctedHistogram.RecordCorrectedValue(sample-baseLatency, connectionInterval))
+		avgRequestTime = (avgRequestTime*float64(successTotal-1) + float64(sample)/1e6) / float64(successTotal)
+		if interval != nil {
+			interval.recordSuccess(sample - baseLatency)
+		}
+		if stability != nil {
+			stability.record(sample - baseLatency)
+		}
+		if phase >= 0 {
+			b.phases[phase].SuccessTotal++
+			_ = b.phases[phase].SuccessHistogram.RecordValue(sample - baseLatency)
+		}
+	}
+
+	recordError := func(err error, phase int) {
+		errorTotal++
+		if phase >= 0 {
+			b.phases[phase].ErrorTotal++
+		}
+		b.errors[err.Error()]++
+		if interval != nil {
+			interval.recordError(err.Error())
+		}
+	}
+
+	record := func(r result) {
+		receivedTotal++
+		if r.warmup {
+			return
+		}
+		_ = b.sendDelayHistogram.RecordValue(r.sendDelay)
+		if r.size >= 0 {
+			_ = b.requestSizes.RecordValue(r.size)
+		}
+		if errors.Is(r.err, ErrSlow) {
+			slowTotal++
+			if interval != nil {
+				interval.stats.SlowTotal++
+			}
+		} else if r.err != nil {
+			recordError(r.err, r.phase)
+		} else {
+			recordSuccess(r.latency, r.phase)
+		}
+	}
+
 	for {
 		select {
-		case sample := <-results:
-			successTotal++
-			maybePanic(b.successHistogram.RecordValue(sample - baseLatency))
-			avgRequestTime = (avgRequestTime*float64(successTotal-1) + float64(sample/1e6)) / float64(successTotal)
-		case err := <-errors:
-			b.errors[err.Error()]++
+		case r := <-results:
+			record(r)
+		case now := <-intervalTicks:
+			if measuring == nil {
+				interval.flush(now)
+			}
+		case <-stabilityTicks:
+			if measuring == nil && stability.sample() {
+				close(stable)
+				stabilityTicks = nil
+			}
+		case <-measuring:
+			// Intervals are reported from the start of the measurement
+			if interval != nil {
+				interval.stats.Start = time.Now()
+			}
+			measuring = nil
 		case <-doneCh:
+			// Record results which were sent before the collector was stopped
+		drain:
+			for {
+				select {
+				case r := <-results:
+					record(r)
+				default:
+					break drain
+				}
+			}
+
+			if interval != nil {
+				interval.flush(time.Now())
+			}
 			b.avgRequestTime = avgRequestTime
+			b.successTotal = uint64(successTotal)
+			b.errorTotal = errorTotal
+			b.slowTotal = slowTotal
+
+			// Whatever was sent but not received is still in flight, and no
+			// request starts anymore
+			b.droppedTotal = b.stopSends() - receivedTotal
 			return
 		}
 	}
@@ -190,7 +518,59 @@ func detectOsTimerResolution() time.Duration {
 	return bestTimerRes
 }
 
-func (b *Benchmark) tickerFunc(doneCh chan<- struct{}, outCh chan<- time.Time, forceTightTicker bool) {
+// tickDispatcher hands ticks over to the workers. Either all workers share a
+// single channel, or every worker has its own channel and ticks are assigned
+// to them in round-robin fashion.
+type tickDispatcher struct {
+	channels []chan time.Time
+	next     int
+}
+
+func newTickDispatcher(connections uint64, perConnection bool) *tickDispatcher {
+	count := uint64(1)
+	if perConnection {
+		count = connections
+	}
+
+	d := &tickDispatcher{channels: make([]chan time.Time, count)}
+	for i := range d.channels {
+		d.channels[i] = make(chan time.Time)
+	}
+	return d
+}
+
+// channel returns the channel the given worker receives its ticks from.
+func (d *tickDispatcher) channel(worker uint64) <-chan time.Time {
+	return d.channels[worker%uint64(len(d.channels))]
+}
+
+// send passes the tick to a worker, it returns false if no worker was ready
+// to accept it.
+func (d *tickDispatcher) send(tick time.Time) bool {
+	ch := d.channels[d.next]
+	d.next = (d.next + 1) % len(d.channels)
+
+	select {
+	case ch <- tick:
+		return true
+	default:
+		return false
+	}
+}
+
+// sendBlocking passes the tick to a worker, waiting until it's ready.
+func (d *tickDispatcher) sendBlocking(tick time.Time) {
+	d.channels[d.next] <- tick
+	d.next = (d.next + 1) % len(d.channels)
+}
+
+func (d *tickDispatcher) close() {
+	for _, ch := range d.channels {
+		close(ch)
+	}
+}
+
+func (b *Benchmark) tickerFunc(doneCh chan<- struct{}, measuring chan<- struct{}, stable <-chan struct{}, outCh *tickDispatcher, forceTightTicker bool) {
 	timerRes := detectOsTimerResolution()
 	fmt.Printf("ExpectedInterval = %v, Detected OS timer resolution = %v\n", b.expectedInterval, timerRes)
 	if timerRes*3 > b.expectedInterval {
@@ -202,16 +582,29 @@ func (b *Benchmark) tickerFunc(doneCh chan<- struct{}, outCh chan<- time.Time, f
 
 	if !forceTightTicker && b.expectedInterval >= 7*timerRes {
 		fmt.Println("Using sleeping ticker")
-		b.sleepingTicker(doneCh, outCh)
+		b.sleepingTicker(doneCh, measuring, stable, outCh)
 	} else {
 		fmt.Println("Using tight ticker")
-		b.tightTicker(doneCh, outCh)
+		b.tightTicker(doneCh, measuring, stable, outCh)
 	}
 }
 
-func (b *Benchmark) tightTicker(doneCh chan<- struct{}, outCh chan<- time.Time) {
+// startWarmup sets the start of the measurement, it returns true if there's
+// a Warmup to run first. Otherwise the measuring channel is closed right away.
+func (b *Benchmark) startWarmup(start time.Time, measuring chan<- struct{}) bool {
+	b.measureStart = start.Add(b.Warmup)
+	if b.Warmup > 0 {
+		fmt.Printf("Warming up for %v\n", b.Warmup)
+		return true
+	}
+	close(measuring)
+	return false
+}
+
+func (b *Benchmark) tightTicker(doneCh chan<- struct{}, measuring chan<- struct{}, stable <-chan struct{}, outCh *tickDispatcher) {
 	start := time.Now()
 	lastTick := start
+	warmup := b.startWarmup(start, measuring)
 
 	var (
 		timelyTicks uint64
@@ -219,7 +612,7 @@ func (b *Benchmark) tightTicker(doneCh chan<- struct{}, outCh chan<- time.Time)
 	)
 
 	expectedInterval := b.expectedInterval
-	duration := b.duration
+	duration := b.Warmup + b.duration
 
 	for {
 		var thisTick time.Time
@@ -232,33 +625,42 @@ func (b *Benchmark) tightTicker(doneCh chan<- struct{}, outCh chan<- time.Time)
 			}
 		}
 
-		select {
-		case outCh <- thisTick:
+		if warmup && !thisTick.Before(b.measureStart) {
+			warmup = false
+			timelyTicks, missedTicks = 0, 0
+			close(measuring)
+		}
+
+		if outCh.send(thisTick) {
 			timelyTicks++
-		default:
+		} else {
 			missedTicks++
 		}
 
-		if thisTick.Sub(start) > duration {
+		if thisTick.Sub(start) > duration || isClosed(stable) {
 			// log.Println("Signaling DONE")
-			close(outCh)
+			outCh.close()
 			break
 		}
 	}
 
-	close(doneCh)
-	b.elapsed = time.Since(start)
-
+	if warmup {
+		close(measuring)
+	}
+	// Set before signaling, Run reads them once the ticker is done
+	b.elapsed = time.Since(b.measureStart)
 	b.timelyTicks = timelyTicks
 	b.missedTicks = missedTicks
+	close(doneCh)
 }
 
-func (b *Benchmark) sleepingTicker(doneCh chan<- struct{}, outCh chan<- time.Time) {
-	completion := time.After(b.duration)
+func (b *Benchmark) sleepingTicker(doneCh chan<- struct{}, measuring chan<- struct{}, stable <-chan struct{}, outCh *tickDispatcher) {
+	completion := time.After(b.Warmup + b.duration)
 
 	inCh := time.Tick(b.expectedInterval)
 
 	start := time.Now()
+	warmup := b.startWarmup(start, measuring)
 
 	var (
 		timelyTicks uint64
@@ -266,32 +668,52 @@ func (b *Benchmark) sleepingTicker(doneCh chan<- struct{}, outCh chan<- time.Tim
 	)
 
 	// initial tick
-	outCh <- start
+	outCh.sendBlocking(start)
 	timelyTicks++
 
 loop:
 	for {
 		select {
 		case t := <-inCh:
-			select {
-			case outCh <- t:
+			if warmup && !t.Before(b.measureStart) {
+				warmup = false
+				timelyTicks, missedTicks = 0, 0
+				close(measuring)
+			}
+
+			if outCh.send(t) {
 				timelyTicks++
-			default:
+			} else {
 				missedTicks++
 			}
 
 		case <-completion:
 			// log.Println("Signaling DONE")
-			close(outCh)
+			outCh.close()
+			break loop
+
+		case <-stable:
+			outCh.close()
 			break loop
 		}
 	}
 
-	close(doneCh)
-	b.elapsed = time.Since(start)
-
+	if warmup {
+		close(measuring)
+	}
+	b.elapsed = time.Since(b.measureStart)
 	b.timelyTicks = timelyTicks
 	b.missedTicks = missedTicks
+	close(doneCh)
+}
+
+func isClosed(ch <-chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
 }
 
 func maybePanic(err error) {
@@ -300,44 +722,76 @@ func maybePanic(err error) {
 	}
 }
 
-func (b *Benchmark) worker(requester Requester, ticker <-chan time.Time, results chan<- int64, errors chan<- error) {
+func (b *Benchmark) worker(requester Requester, ticker <-chan time.Time, results chan<- result, collectorStopped <-chan struct{}) {
 	maybePanic(requester.Setup())
+	sizer, _ := requester.(RequestSizer)
+	measurer, _ := requester.(LatencyMeasurer)
 
 	// initialized to 0 by default
 	var (
-		lateSends    uint64
-		timelySends  uint64
-		errorTotal   uint64
-		successTotal uint64
+		lateSends   uint64
+		timelySends uint64
 	)
 
 	for tick := range ticker {
+		if b.RateLimiter != nil && !b.RateLimiter.Allow() {
+			if !tick.Before(b.measureStart) {
+				atomic.AddUint64(&b.rateLimited, 1)
+			}
+			continue
+		}
+
+		if !b.startSend() {
+			continue
+		}
 		before := time.Now()
-		if before.Sub(tick) >= b.expectedInterval {
-			lateSends++
-		} else {
-			timelySends++
+		sendDelay := before.Sub(tick)
+		warmup := tick.Before(b.measureStart)
+		if !warmup {
+			if sendDelay >= b.expectedInterval {
+				lateSends++
+			} else {
+				timelySends++
+			}
 		}
 
 		err := requester.Request()
 		latency := time.Since(before).Nanoseconds()
-		if err != nil {
-			errorTotal++
-			errors <- err
-		} else {
-			// On Linux, sometimes time interval measurement comes back negative, report it as 0
-			if latency < 0 {
-				latency = 0
+		if measurer != nil {
+			if measured, ok := measurer.MeasuredLatency(); ok {
+				latency = measured.Nanoseconds()
 			}
-			results <- latency
-			successTotal++
+		}
+
+		size := int64(-1)
+		if sizer != nil {
+			if s, ok := sizer.RequestSize(); ok {
+				size = s
+			}
+		}
+
+		// On Linux, sometimes time interval measurement comes back negative, report it as 0
+		if latency < 0 {
+			latency = 0
+		}
+		if sendDelay < 0 {
+			sendDelay = 0
+		}
+
+		select {
+		case results <- result{latency: latency, sendDelay: sendDelay.Nanoseconds(), err: err, warmup: warmup, phase: b.phaseAt(tick), size: size}:
+		case <-collectorStopped:
 		}
 	}
 
-	atomic.AddUint64(&b.lateSends, lateSends)
-	atomic.AddUint64(&b.timelySends, timelySends)
-	atomic.AddUint64(&b.errorTotal, errorTotal)
-	atomic.AddUint64(&b.successTotal, successTotal)
+	b.sendMu.RLock()
+	// Once sends are stopped the results were already summarized, this
+	// worker's last request was dropped at shutdown
+	if !b.sendsStopped {
+		atomic.AddUint64(&b.lateSends, lateSends)
+		atomic.AddUint64(&b.timelySends, timelySends)
+	}
+	b.sendMu.RUnlock()
 
 	err := requester.Teardown()
 	if err != nil {
@@ -345,6 +799,38 @@ func (b *Benchmark) worker(requester Requester, ticker <-chan time.Time, results
 	}
 }
 
+// startSend counts a request about to be sent. It returns false once sends
+// are stopped, as the result of the request wouldn't be collected.
+func (b *Benchmark) startSend() bool {
+	b.sendMu.RLock()
+	defer b.sendMu.RUnlock()
+	if b.sendsStopped {
+		return false
+	}
+	atomic.AddUint64(&b.sent, 1)
+	return true
+}
+
+// stopSends keeps any further request from starting, and returns the number
+// of requests sent. The requests in flight can't be counted as sent after
+// that.
+func (b *Benchmark) stopSends() uint64 {
+	b.sendMu.Lock()
+	defer b.sendMu.Unlock()
+	b.sendsStopped = true
+	return atomic.LoadUint64(&b.sent)
+}
+
+// cancelRequests cancels the requests in flight of the Requesters which
+// support it.
+func cancelRequests(requesters []Requester) {
+	for _, requester := range requesters {
+		if canceler, ok := requester.(RequestCanceler); ok {
+			canceler.CancelRequest()
+		}
+	}
+}
+
 // summarize returns a Summary of the last benchmark run.
 func (b *Benchmark) summarize(outputJson bool) *Summary {
 
@@ -372,19 +858,32 @@ func (b *Benchmark) summarize(outputJson bool) *Summary {
 	}
 
 	return &Summary{
-		SuccessTotal:     b.successTotal,
-		ErrorTotal:       b.errorTotal,
-		TimeElapsed:      b.elapsed,
-		SuccessHistogram: hdrhistogram.Import(b.successHistogram.Export()),
-		Throughput:       float64(b.successTotal+b.errorTotal) / b.elapsed.Seconds(),
-		AvgRequestTime:   b.avgRequestTime,
-		RequestRate:      b.requestRate,
-		Connections:      b.connections,
-		Errors:           formattedErrors,
-		TicksTimely:      b.timelyTicks,
-		TicksTimelyRatio: float64(b.timelyTicks) * 100 / float64(b.timelyTicks+b.missedTicks),
-		SendsTimely:      b.timelySends,
-		SendsTimelyRatio: float64(b.timelySends) * 100 / float64(b.timelySends+b.lateSends),
-		OutputJson:       outputJson,
+		SuccessTotal:       b.successTotal,
+		ErrorTotal:         b.errorTotal,
+		SlowTotal:          b.slowTotal,
+		RateLimited:        atomic.LoadUint64(&b.rateLimited),
+		DroppedTotal:       b.droppedTotal,
+		TimeElapsed:        b.elapsed,
+		SuccessHistogram:   hdrhistogram.Import(b.successHistogram.Export()),
+		CorrectedHistogram: hdrhistogram.Import(b.correctedHistogram.Export()),
+		Throughput:         float64(b.successTotal+b.errorTotal+b.slowTotal) / b.elapsed.Seconds(),
+		AvgRequestTime:     b.avgRequestTime,
+		RequestRate:        b.requestRate,
+		PerConnectionRate:  b.PerConnectionRate,
+		Connections:        b.connections,
+		Errors:             formattedErrors,
+		TicksTimely:        b.timelyTicks,
+		TicksTimelyRatio:   float64(b.timelyTicks) * 100 / float64(b.timelyTicks+b.missedTicks),
+		SendsTimely:        b.timelySends,
+		SendsTimelyRatio:   float64(b.timelySends) * 100 / float64(b.timelySends+b.lateSends),
+		SendDelayHistogram: hdrhistogram.Import(b.sendDelayHistogram.Export()),
+		GCCount:            b.gcStats.count,
+		GCPauseTotal:       b.gcStats.pauseTotal,
+		GCPauseMax:         b.gcStats.pauseMax,
+		Phases:             b.phases,
+		SchedulingDelay:    hdrhistogram.Import(b.schedulingDelay.Export()),
+		RequestSizes:       hdrhistogram.Import(b.requestSizes.Export()),
+		HeadlineMetric:     b.HeadlineMetric,
+		OutputJson:         outputJson,
 	}
 }
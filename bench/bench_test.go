@@ -0,0 +1,72 @@
+package bench
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// blockingFactory creates Requesters whose requests succeed right away, until
+// fast requests were sent in total. The next ones block until canceled.
+type blockingFactory struct {
+	fast     uint64
+	started  uint64
+	canceled uint64
+}
+
+func (f *blockingFactory) GetRequester(uint64) Requester {
+	return &blockingRequester{factory: f, cancel: make(chan struct{})}
+}
+
+type blockingRequester struct {
+	factory *blockingFactory
+	cancel  chan struct{}
+	once    sync.Once
+}
+
+func (r *blockingRequester) Setup() error { return nil }
+
+func (r *blockingRequester) Request() error {
+	if atomic.AddUint64(&r.factory.started, 1) <= r.factory.fast {
+		return nil
+	}
+	<-r.cancel
+	return errors.New("canceled")
+}
+
+func (r *blockingRequester) CancelRequest() {
+	r.once.Do(func() {
+		atomic.AddUint64(&r.factory.canceled, 1)
+		close(r.cancel)
+	})
+}
+
+func (r *blockingRequester) Teardown() error { return nil }
+
+func TestDrainTimeoutDropsRequestsInFlight(t *testing.T) {
+	const connections = 5
+	factory := &blockingFactory{fast: 10}
+	b := NewBenchmark(factory, 100, connections, 500*time.Millisecond, 0)
+	b.DrainTimeout = 50 * time.Millisecond
+
+	summary, err := b.Run(false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every connection is stuck on its request after the fast ones
+	if summary.SuccessTotal != factory.fast || summary.ErrorTotal != 0 {
+		t.Errorf("SuccessTotal = %d, ErrorTotal = %d, want %d and 0", summary.SuccessTotal, summary.ErrorTotal, factory.fast)
+	}
+	if summary.DroppedTotal != connections {
+		t.Errorf("DroppedTotal = %d, want %d", summary.DroppedTotal, connections)
+	}
+	if started := atomic.LoadUint64(&factory.started); started != factory.fast+connections {
+		t.Errorf("%d requests started, want %d", started, factory.fast+connections)
+	}
+	if canceled := atomic.LoadUint64(&factory.canceled); canceled != connections {
+		t.Errorf("%d requesters canceled, want %d", canceled, connections)
+	}
+}
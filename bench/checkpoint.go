@@ -0,0 +1,134 @@
+package bench
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/codahale/hdrhistogram"
+)
+
+// Checkpoint is a persisted snapshot of the cumulative results of a Benchmark
+// run. It allows a long run which was interrupted to be resumed later without
+// losing the results collected so far.
+type Checkpoint struct {
+	SuccessTotal uint64
+	ErrorTotal   uint64
+	SlowTotal    uint64
+	TimeElapsed  time.Duration
+	Errors       map[string]int
+	Histogram    *hdrhistogram.Snapshot
+}
+
+// LoadCheckpoint reads a Checkpoint previously saved to the given file.
+func LoadCheckpoint(file string) (*Checkpoint, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var checkpoint Checkpoint
+	if err := json.Unmarshal(content, &checkpoint); err != nil {
+		return nil, err
+	}
+
+	return &checkpoint, nil
+}
+
+// Save writes the Checkpoint to the given file. The file is replaced
+// atomically so a crash while saving doesn't corrupt the previous checkpoint.
+func (c *Checkpoint) Save(file string) error {
+	content, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+
+	tmpFile := file + ".tmp"
+	if err := ioutil.WriteFile(tmpFile, content, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpFile, file)
+}
+
+// Checkpointer implements IntervalReporter by accumulating the results of
+// every interval and saving them as a Checkpoint.
+type Checkpointer struct {
+	file       string
+	checkpoint Checkpoint
+	histogram  *hdrhistogram.Histogram
+}
+
+// NewCheckpointer creates a Checkpointer saving to the given file. If
+// resumeFrom is not nil, its results are included in every saved Checkpoint.
+func NewCheckpointer(file string, resumeFrom *Checkpoint) *Checkpointer {
+	c := &Checkpointer{
+		file:       file,
+		checkpoint: Checkpoint{Errors: make(map[string]int)},
+	}
+
+	if resumeFrom != nil {
+		c.checkpoint.SuccessTotal = resumeFrom.SuccessTotal
+		c.checkpoint.ErrorTotal = resumeFrom.ErrorTotal
+		c.checkpoint.SlowTotal = resumeFrom.SlowTotal
+		c.checkpoint.TimeElapsed = resumeFrom.TimeElapsed
+		for errorText, count := range resumeFrom.Errors {
+			c.checkpoint.Errors[errorText] = count
+		}
+		if resumeFrom.Histogram != nil {
+			c.histogram = hdrhistogram.Import(resumeFrom.Histogram)
+		}
+	}
+
+	return c
+}
+
+// ReportInterval merges the interval results and saves the Checkpoint.
+func (c *Checkpointer) ReportInterval(stats *IntervalStats) error {
+	c.checkpoint.SuccessTotal += stats.SuccessTotal
+	c.checkpoint.ErrorTotal += stats.ErrorTotal
+	c.checkpoint.SlowTotal += stats.SlowTotal
+	c.checkpoint.TimeElapsed += stats.Duration
+	for errorText, count := range stats.Errors {
+		c.checkpoint.Errors[errorText] += count
+	}
+	if c.histogram == nil {
+		// Same range as the interval histograms, see Benchmark.HistogramMin
+		c.histogram = hdrhistogram.New(stats.Histogram.LowestTrackableValue(), stats.Histogram.HighestTrackableValue(), int(stats.Histogram.SignificantFigures()))
+	}
+	c.histogram.Merge(stats.Histogram)
+
+	c.checkpoint.Histogram = c.histogram.Export()
+	return c.checkpoint.Save(c.file)
+}
+
+// Resume merges the results saved in a Checkpoint into the Summary, as if
+// they were collected during the same run.
+func (s *Summary) Resume(c *Checkpoint) {
+	if c.Histogram != nil {
+		saved := hdrhistogram.Import(c.Histogram)
+		if total := s.SuccessTotal + c.SuccessTotal; total > 0 {
+			savedAvg := saved.Mean() / 1000000
+			s.AvgRequestTime = (s.AvgRequestTime*float64(s.SuccessTotal) + savedAvg*float64(c.SuccessTotal)) / float64(total)
+		}
+		s.SuccessHistogram.Merge(saved)
+		// Checkpoints don't keep the corrected histogram, the saved latencies
+		// are merged as they are
+		if s.CorrectedHistogram != nil {
+			s.CorrectedHistogram.Merge(saved)
+		}
+	}
+
+	s.SuccessTotal += c.SuccessTotal
+	s.ErrorTotal += c.ErrorTotal
+	s.SlowTotal += c.SlowTotal
+	s.TimeElapsed += c.TimeElapsed
+	for errorText, count := range c.Errors {
+		s.Errors[errorText] += count
+	}
+
+	if s.TimeElapsed > 0 {
+		s.Throughput = float64(s.requestTotal()) / s.TimeElapsed.Seconds()
+	}
+}
@@ -0,0 +1,55 @@
+package bench
+
+import (
+	"runtime"
+	"time"
+)
+
+// gcStats tracks garbage collections of the benchmark process during the
+// measurement, as GC pauses delay the requests and show up as latency.
+type gcStats struct {
+	start      runtime.MemStats
+	count      uint32
+	pauseTotal time.Duration
+	pauseMax   time.Duration
+}
+
+// gcFunc forces a GC every GCInterval until the measurement starts, then
+// takes a snapshot of the GC stats.
+func (b *Benchmark) gcFunc(measuring <-chan struct{}) {
+	if b.GCInterval > 0 && b.Warmup > 0 {
+		gcTicker := time.NewTicker(b.GCInterval)
+		defer gcTicker.Stop()
+
+	warmup:
+		for {
+			select {
+			case <-gcTicker.C:
+				runtime.GC()
+			case <-measuring:
+				break warmup
+			}
+		}
+	}
+
+	<-measuring
+	runtime.ReadMemStats(&b.gcStats.start)
+}
+
+// finish computes the GC stats since the snapshot.
+func (s *gcStats) finish() {
+	var end runtime.MemStats
+	runtime.ReadMemStats(&end)
+
+	s.count = end.NumGC - s.start.NumGC
+	s.pauseTotal = time.Duration(end.PauseTotalNs - s.start.PauseTotalNs)
+
+	// Only the most recent pauses are kept in the circular buffer
+	s.pauseMax = 0
+	for i := end.NumGC; i > s.start.NumGC && end.NumGC-i < uint32(len(end.PauseNs)); i-- {
+		pause := time.Duration(end.PauseNs[(i+uint32(len(end.PauseNs))-1)%uint32(len(end.PauseNs))])
+		if pause > s.pauseMax {
+			s.pauseMax = pause
+		}
+	}
+}
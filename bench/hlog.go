@@ -0,0 +1,132 @@
+package bench
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"time"
+
+	"github.com/codahale/hdrhistogram"
+)
+
+// Cookies of the HdrHistogram V2 encoding with 8 byte words
+const (
+	hlogEncodingCookie           = 0x1c849303 | 0x10
+	hlogCompressedEncodingCookie = 0x1c849304 | 0x10
+)
+
+// HlogWriter implements IntervalReporter by writing the latency histogram of
+// every interval to a file in HdrHistogram interval log (.hlog) format, which
+// keeps all the buckets for later analysis, e.g. by HistogramLogProcessor.
+// Latencies are in nanoseconds, the max of each interval is in milliseconds.
+type HlogWriter struct {
+	file   *os.File
+	writer *bufio.Writer
+	start  time.Time
+}
+
+// NewHlogWriter creates the log file, its header is written along with the
+// first interval.
+func NewHlogWriter(file string) (*HlogWriter, error) {
+	f, err := os.Create(file)
+	if err != nil {
+		return nil, err
+	}
+
+	return &HlogWriter{file: f, writer: bufio.NewWriter(f)}, nil
+}
+
+// ReportInterval writes the histogram of the interval.
+func (w *HlogWriter) ReportInterval(stats *IntervalStats) error {
+	if w.start.IsZero() {
+		w.start = stats.Start
+		fmt.Fprintf(w.writer, "#[Histogram log format version 1.3]\n")
+		fmt.Fprintf(w.writer, "#[StartTime: %.3f (seconds since epoch), %s]\n",
+			float64(w.start.UnixNano())/1e9, w.start.Format(time.RFC1123))
+		fmt.Fprintf(w.writer, "\"StartTimestamp\",\"Interval_Length\",\"Interval_Max\",\"Interval_Compressed_Histogram\"\n")
+	}
+
+	encoded, err := encodeHistogram(stats.Histogram)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w.writer, "%.3f,%.3f,%.3f,%s\n",
+		stats.Start.Sub(w.start).Seconds(), stats.Duration.Seconds(), float64(stats.Histogram.Max())/1000000, encoded)
+	return err
+}
+
+// Close flushes and closes the log file.
+func (w *HlogWriter) Close() error {
+	if err := w.writer.Flush(); err != nil {
+		return err
+	}
+	return w.file.Close()
+}
+
+// encodeHistogram returns the histogram in the compressed V2 encoding of
+// HdrHistogram, base64 encoded.
+func encodeHistogram(h *hdrhistogram.Histogram) (string, error) {
+	snapshot := h.Export()
+
+	// Counts are encoded up to the last non-zero one, as ZigZag LEB128 with
+	// runs of zeros encoded as negative numbers
+	limit := len(snapshot.Counts)
+	for limit > 0 && snapshot.Counts[limit-1] == 0 {
+		limit--
+	}
+
+	var payload bytes.Buffer
+	varint := make([]byte, binary.MaxVarintLen64)
+	for i := 0; i < limit; {
+		count := snapshot.Counts[i]
+		i++
+		if count == 0 {
+			zeros := int64(1)
+			for i < limit && snapshot.Counts[i] == 0 {
+				zeros++
+				i++
+			}
+			if zeros > 1 {
+				count = -zeros
+			}
+		}
+		payload.Write(varint[:binary.PutVarint(varint, count)])
+	}
+
+	var encoded bytes.Buffer
+	header := []interface{}{
+		int32(hlogEncodingCookie),
+		int32(payload.Len()),
+		int32(0), // normalizing index offset
+		int32(snapshot.SignificantFigures),
+		snapshot.LowestTrackableValue,
+		snapshot.HighestTrackableValue,
+		math.Float64bits(1), // integer to double value conversion ratio
+	}
+	for _, field := range header {
+		_ = binary.Write(&encoded, binary.BigEndian, field)
+	}
+	encoded.Write(payload.Bytes())
+
+	var compressed bytes.Buffer
+	zw := zlib.NewWriter(&compressed)
+	if _, err := zw.Write(encoded.Bytes()); err != nil {
+		return "", err
+	}
+	if err := zw.Close(); err != nil {
+		return "", err
+	}
+
+	var out bytes.Buffer
+	_ = binary.Write(&out, binary.BigEndian, int32(hlogCompressedEncodingCookie))
+	_ = binary.Write(&out, binary.BigEndian, int32(compressed.Len()))
+	out.Write(compressed.Bytes())
+
+	return base64.StdEncoding.EncodeToString(out.Bytes()), nil
+}
@@ -0,0 +1,232 @@
+package bench
+
+import (
+	// embed is needed for the report template
+	_ "embed"
+	"fmt"
+	"html/template"
+	"math"
+	"os"
+	"strings"
+	"time"
+)
+
+//go:embed report.html
+var reportTemplate string
+
+// HTMLReport implements IntervalReporter by collecting the time series of a
+// Benchmark run, and renders a self-contained HTML report with the latency
+// distribution, time series and summary tables.
+type HTMLReport struct {
+	start  time.Time
+	points []reportPoint
+}
+
+type reportPoint struct {
+	elapsed    float64
+	throughput float64
+	errors     float64
+	p50        float64
+	p99        float64
+}
+
+// NewHTMLReport creates an HTMLReport.
+func NewHTMLReport() *HTMLReport {
+	return &HTMLReport{}
+}
+
+// ReportInterval adds the interval to the time series.
+func (r *HTMLReport) ReportInterval(stats *IntervalStats) error {
+	if r.start.IsZero() {
+		r.start = stats.Start
+	}
+
+	var errorRate float64
+	if stats.Duration > 0 {
+		errorRate = float64(stats.ErrorTotal) / stats.Duration.Seconds()
+	}
+
+	r.points = append(r.points, reportPoint{
+		elapsed:    stats.Start.Add(stats.Duration).Sub(r.start).Seconds(),
+		throughput: stats.Throughput(),
+		errors:     errorRate,
+		p50:        stats.Percentile(50),
+		p99:        stats.Percentile(99),
+	})
+	return nil
+}
+
+// Generate writes the HTML report for the Summary to the given file.
+func (r *HTMLReport) Generate(s *Summary, file string) error {
+	tmpl, err := template.New("report").Parse(reportTemplate)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return tmpl.Execute(f, r.reportData(s))
+}
+
+type reportData struct {
+	Generated string
+	Summary   *Summary
+	Metrics   [][]string
+	Errors    [][]string
+	Charts    []*svgChart
+}
+
+func (r *HTMLReport) reportData(s *Summary) *reportData {
+	data := &reportData{
+		Generated: time.Now().Format(time.RFC1123),
+		Summary:   s,
+		Metrics:   s.metricsRows(),
+		Errors:    s.errorRows(),
+	}
+
+	// Latency by percentile with logarithmic X axis, like the hgrm plotter
+	var percentileX, percentileY []float64
+	for _, percentile := range Logarithmic {
+		if percentile >= 100 {
+			break
+		}
+		percentileX = append(percentileX, math.Log10(1/(1-percentile/100)))
+		percentileY = append(percentileY, float64(s.SuccessHistogram.ValueAtQuantile(percentile))/1000000)
+	}
+	distribution := newSVGChart("Latency by Percentile", "ms", []svgSeries{{Name: "Latency", Color: "#1f77b4"}}, percentileX, [][]float64{percentileY})
+	distribution.XTicks = nil
+	for i, label := range []string{"0%", "90%", "99%", "99.9%", "99.99%", "99.999%"} {
+		if float64(i) <= distribution.maxX {
+			distribution.XTicks = append(distribution.XTicks, svgTick{Pos: distribution.x(float64(i)), Label: label})
+		}
+	}
+	data.Charts = append(data.Charts, distribution)
+
+	if len(r.points) > 0 {
+		var elapsed, throughput, errors, p50, p99 []float64
+		for _, p := range r.points {
+			elapsed = append(elapsed, p.elapsed)
+			throughput = append(throughput, p.throughput)
+			errors = append(errors, p.errors)
+			p50 = append(p50, p.p50)
+			p99 = append(p99, p.p99)
+		}
+
+		data.Charts = append(data.Charts,
+			newSVGChart("Throughput over Time", "req/sec", []svgSeries{{Name: "Throughput", Color: "#2ca02c"}, {Name: "Errors", Color: "#d62728"}}, elapsed, [][]float64{throughput, errors}),
+			newSVGChart("Latency over Time", "ms", []svgSeries{{Name: "P50", Color: "#1f77b4"}, {Name: "P99", Color: "#ff7f0e"}}, elapsed, [][]float64{p50, p99}))
+	}
+
+	return data
+}
+
+// The chart geometry must match the SVG in report.html.
+const (
+	chartWidth  = 800
+	chartHeight = 300
+	chartLeft   = 60
+	chartRight  = 20
+	chartTop    = 20
+	chartBottom = 40
+)
+
+// svgChart is a line chart rendered as inline SVG, so the report has no
+// external dependencies.
+type svgChart struct {
+	Title  string
+	YLabel string
+	Series []svgSeries
+	XTicks []svgTick
+	YTicks []svgTick
+
+	maxX float64
+	maxY float64
+}
+
+type svgSeries struct {
+	Name    string
+	Color   string
+	Points  string
+	LegendX float64
+}
+
+type svgTick struct {
+	Pos   float64
+	Label string
+}
+
+func newSVGChart(title, yLabel string, series []svgSeries, xs []float64, ys [][]float64) *svgChart {
+	c := &svgChart{Title: title, YLabel: yLabel, Series: series}
+
+	for _, x := range xs {
+		c.maxX = math.Max(c.maxX, x)
+	}
+	for _, values := range ys {
+		for _, y := range values {
+			c.maxY = math.Max(c.maxY, y)
+		}
+	}
+	if c.maxX == 0 {
+		c.maxX = 1
+	}
+
+	yStep := niceStep(c.maxY / 5)
+	c.maxY = math.Ceil(c.maxY/yStep) * yStep
+	if c.maxY == 0 {
+		c.maxY = yStep
+	}
+	for v := 0.0; v <= c.maxY+yStep/2; v += yStep {
+		c.YTicks = append(c.YTicks, svgTick{Pos: c.y(v), Label: formatTick(v)})
+	}
+
+	xStep := niceStep(c.maxX / 8)
+	for v := 0.0; v <= c.maxX+xStep/2; v += xStep {
+		c.XTicks = append(c.XTicks, svgTick{Pos: c.x(v), Label: formatTick(v)})
+	}
+
+	for i, values := range ys {
+		points := make([]string, 0, len(values))
+		for j, y := range values {
+			points = append(points, fmt.Sprintf("%.1f,%.1f", c.x(xs[j]), c.y(y)))
+		}
+		c.Series[i].Points = strings.Join(points, " ")
+		c.Series[i].LegendX = chartWidth - chartRight - float64(len(ys)-i)*100
+	}
+
+	return c
+}
+
+func (c *svgChart) x(v float64) float64 {
+	return math.Round((chartLeft+v/c.maxX*(chartWidth-chartLeft-chartRight))*10) / 10
+}
+
+func (c *svgChart) y(v float64) float64 {
+	return math.Round((chartHeight-chartBottom-v/c.maxY*(chartHeight-chartTop-chartBottom))*10) / 10
+}
+
+// niceStep rounds the step up to 1, 2 or 5 times a power of 10.
+func niceStep(step float64) float64 {
+	if step <= 0 {
+		return 1
+	}
+
+	magnitude := math.Pow(10, math.Floor(math.Log10(step)))
+	switch normalized := step / magnitude; {
+	case normalized <= 1:
+		return magnitude
+	case normalized <= 2:
+		return 2 * magnitude
+	case normalized <= 5:
+		return 5 * magnitude
+	default:
+		return 10 * magnitude
+	}
+}
+
+func formatTick(v float64) string {
+	return strings.TrimSuffix(strings.TrimRight(fmt.Sprintf("%.3f", v), "0"), ".")
+}
@@ -0,0 +1,86 @@
+package bench
+
+import (
+	"log"
+	"time"
+
+	"github.com/codahale/hdrhistogram"
+)
+
+// IntervalStats contains the results collected during a single reporting
+// interval of a Benchmark run.
+type IntervalStats struct {
+	Start        time.Time
+	Duration     time.Duration
+	SuccessTotal uint64
+	ErrorTotal   uint64
+	SlowTotal    uint64
+	Errors       map[string]int
+	Histogram    *hdrhistogram.Histogram
+}
+
+// Throughput returns the number of requests completed per second during the
+// interval, including failed and slow ones.
+func (s *IntervalStats) Throughput() float64 {
+	if s.Duration <= 0 {
+		return 0
+	}
+	return float64(s.SuccessTotal+s.ErrorTotal+s.SlowTotal) / s.Duration.Seconds()
+}
+
+// Percentile returns the latency at the given percentile in milliseconds.
+func (s *IntervalStats) Percentile(percentile float64) float64 {
+	return float64(s.Histogram.ValueAtQuantile(percentile)) / 1000000
+}
+
+// IntervalReporter receives the IntervalStats collected during every reporting
+// interval of a Benchmark run.
+type IntervalReporter interface {
+	// ReportInterval is called by the results collector at the end of every
+	// interval. The stats must not be retained after the call returns.
+	ReportInterval(stats *IntervalStats) error
+}
+
+// intervalCollector accumulates the results for the current interval.
+type intervalCollector struct {
+	stats     IntervalStats
+	reporters []IntervalReporter
+}
+
+func newIntervalCollector(reporters []IntervalReporter, minLatency int64) *intervalCollector {
+	return &intervalCollector{
+		stats: IntervalStats{
+			Start:     time.Now(),
+			Errors:    make(map[string]int),
+			Histogram: hdrhistogram.New(minLatency, maxRecordableLatencyNS, sigFigs),
+		},
+		reporters: reporters,
+	}
+}
+
+func (c *intervalCollector) recordSuccess(latency int64) {
+	c.stats.SuccessTotal++
+	_ = c.stats.Histogram.RecordValue(latency)
+}
+
+func (c *intervalCollector) recordError(err string) {
+	c.stats.ErrorTotal++
+	c.stats.Errors[err]++
+}
+
+// flush reports the current interval and starts a new one.
+func (c *intervalCollector) flush(now time.Time) {
+	c.stats.Duration = now.Sub(c.stats.Start)
+	for _, reporter := range c.reporters {
+		if err := reporter.ReportInterval(&c.stats); err != nil {
+			log.Println("Failure in interval reporter:", err)
+		}
+	}
+
+	c.stats.Start = now
+	c.stats.SuccessTotal = 0
+	c.stats.ErrorTotal = 0
+	c.stats.SlowTotal = 0
+	c.stats.Errors = make(map[string]int)
+	c.stats.Histogram.Reset()
+}
@@ -0,0 +1,105 @@
+package bench
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/codahale/hdrhistogram"
+	"github.com/olekukonko/tablewriter"
+)
+
+// Phase is a labeled part of the benchmark duration. Results are reported for
+// every phase in addition to the whole run, e.g. to compare latency before,
+// during and after a deployment.
+type Phase struct {
+	Name     string
+	Duration time.Duration
+}
+
+// PhaseSummary contains the results of a single Phase.
+type PhaseSummary struct {
+	Name             string
+	Duration         time.Duration
+	SuccessTotal     uint64
+	ErrorTotal       uint64
+	SuccessHistogram *hdrhistogram.Histogram
+}
+
+// Throughput returns the number of requests per second sent during the phase.
+func (p *PhaseSummary) Throughput() float64 {
+	if p.Duration <= 0 {
+		return 0
+	}
+	return float64(p.SuccessTotal+p.ErrorTotal) / p.Duration.Seconds()
+}
+
+// phaseAt returns the index of the phase the tick belongs to, -1 if there are
+// no phases.
+func (b *Benchmark) phaseAt(tick time.Time) int {
+	if len(b.Phases) == 0 {
+		return -1
+	}
+
+	elapsed := tick.Sub(b.measureStart)
+	for i, phase := range b.Phases {
+		elapsed -= phase.Duration
+		if elapsed < 0 {
+			return i
+		}
+	}
+	// The last tick may come a bit after the duration is over
+	return len(b.Phases) - 1
+}
+
+func newPhaseSummaries(phases []Phase, minLatency int64) []*PhaseSummary {
+	summaries := make([]*PhaseSummary, len(phases))
+	for i, phase := range phases {
+		summaries[i] = &PhaseSummary{
+			Name:             phase.Name,
+			Duration:         phase.Duration,
+			SuccessHistogram: hdrhistogram.New(minLatency, maxRecordableLatencyNS, sigFigs),
+		}
+	}
+	return summaries
+}
+
+// phasesTable renders the results of every phase.
+func (s *Summary) phasesTable() string {
+	var outputBuffer bytes.Buffer
+	table := tablewriter.NewWriter(&outputBuffer)
+	table.SetHeader([]string{"Phase", "Duration (sec)", "Requests", "Errors", "Throughput (req/sec)", "P50 (ms)", "P99 (ms)"})
+	for _, p := range s.Phases {
+		table.Append([]string{
+			p.Name,
+			strconv.FormatFloat(p.Duration.Seconds(), 'f', 2, 64),
+			strconv.FormatUint(p.SuccessTotal+p.ErrorTotal, 10),
+			strconv.FormatUint(p.ErrorTotal, 10),
+			strconv.FormatFloat(p.Throughput(), 'f', 2, 64),
+			strconv.FormatFloat(float64(p.SuccessHistogram.ValueAtQuantile(50))/1000000, 'f', 2, 64),
+			strconv.FormatFloat(float64(p.SuccessHistogram.ValueAtQuantile(99))/1000000, 'f', 2, 64),
+		})
+	}
+	table.Render()
+	return outputBuffer.String()
+}
+
+// phaseFile returns the name of the distribution file of a phase, e.g.
+// out/res.deploy.hgrm for out/res.hgrm.
+func phaseFile(file, phase string) string {
+	ext := filepath.Ext(file)
+	return strings.TrimSuffix(file, ext) + "." + phase + ext
+}
+
+// generatePhaseDistributions generates a distribution file for every phase.
+func (s *Summary) generatePhaseDistributions(percentiles Percentiles, file string) error {
+	for _, p := range s.Phases {
+		if err := generateLatencyDistribution(p.SuccessHistogram, nil, 0, percentiles, phaseFile(file, p.Name)); err != nil {
+			return fmt.Errorf("phase %s: %v", p.Name, err)
+		}
+	}
+	return nil
+}
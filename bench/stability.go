@@ -0,0 +1,83 @@
+package bench
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/codahale/hdrhistogram"
+)
+
+// StabilityCriterion stops a Benchmark before its duration is over, once the
+// confidence interval of a latency percentile is narrow enough. The percentile
+// is estimated for every SampleInterval, and the 95% confidence interval of
+// the mean of the estimates is computed from their standard deviation.
+type StabilityCriterion struct {
+	// Percentile to watch, e.g. 99.
+	Percentile float64
+
+	// MaxWidthPercent is the width of the confidence interval, relative to the
+	// estimated percentile, below which the results are considered stable.
+	MaxWidthPercent float64
+
+	// SampleInterval is the length of a sample. Defaults to one second.
+	SampleInterval time.Duration
+
+	// MinSamples is the number of samples taken before stability is checked.
+	// Defaults to 10, at least 2 are needed.
+	MinSamples int
+}
+
+// stabilityTracker evaluates the StabilityCriterion in the collector.
+type stabilityTracker struct {
+	criterion StabilityCriterion
+	histogram *hdrhistogram.Histogram
+	estimates []float64
+}
+
+func newStabilityTracker(criterion StabilityCriterion, minLatency int64) *stabilityTracker {
+	if criterion.SampleInterval <= 0 {
+		criterion.SampleInterval = time.Second
+	}
+	if criterion.MinSamples == 0 {
+		criterion.MinSamples = 10
+	} else if criterion.MinSamples < 2 {
+		criterion.MinSamples = 2
+	}
+
+	return &stabilityTracker{
+		criterion: criterion,
+		histogram: hdrhistogram.New(minLatency, maxRecordableLatencyNS, sigFigs),
+	}
+}
+
+func (t *stabilityTracker) record(latency int64) {
+	_ = t.histogram.RecordValue(latency)
+}
+
+// sample ends the current sample, it returns true once the results are stable.
+func (t *stabilityTracker) sample() bool {
+	if t.histogram.TotalCount() == 0 {
+		return false
+	}
+	t.estimates = append(t.estimates, float64(t.histogram.ValueAtQuantile(t.criterion.Percentile))/1000000)
+	t.histogram.Reset()
+
+	n := len(t.estimates)
+	if n < t.criterion.MinSamples {
+		return false
+	}
+
+	mean, stdDev := meanAndStdDev(t.estimates)
+	if mean <= 0 {
+		return false
+	}
+	halfWidth := 1.96 * stdDev / math.Sqrt(float64(n))
+	if 2*halfWidth/mean*100 > t.criterion.MaxWidthPercent {
+		return false
+	}
+
+	fmt.Printf("P%s is stable after %d samples: %.2f ms ± %.2f ms (95%% confidence)\n",
+		formatTick(t.criterion.Percentile), n, mean, halfWidth)
+	return true
+}
@@ -7,6 +7,7 @@ import (
 	"os"
 	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/codahale/hdrhistogram"
@@ -15,20 +16,33 @@ import (
 
 // Summary contains the results of a Benchmark run.
 type Summary struct {
-	Connections      uint64
-	RequestRate      float64
-	SuccessTotal     uint64
-	ErrorTotal       uint64
-	TimeElapsed      time.Duration
-	SuccessHistogram *hdrhistogram.Histogram
-	Throughput       float64
-	AvgRequestTime   float64
-	Errors           map[string]int
-	TicksTimely      uint64
-	TicksTimelyRatio float64
-	SendsTimely      uint64
-	SendsTimelyRatio float64
-	OutputJson       bool
+	Connections        uint64
+	RequestRate        float64
+	PerConnectionRate  bool
+	SuccessTotal       uint64
+	ErrorTotal         uint64
+	SlowTotal          uint64
+	DroppedTotal       uint64
+	RateLimited        uint64
+	TimeElapsed        time.Duration
+	SuccessHistogram   *hdrhistogram.Histogram
+	CorrectedHistogram *hdrhistogram.Histogram // corrected for coordinated omission
+	Throughput         float64
+	AvgRequestTime     float64
+	Errors             map[string]int
+	TicksTimely        uint64
+	TicksTimelyRatio   float64
+	SendsTimely        uint64
+	SendsTimelyRatio   float64
+	SendDelayHistogram *hdrhistogram.Histogram
+	GCCount            uint32
+	GCPauseTotal       time.Duration
+	GCPauseMax         time.Duration
+	Phases             []*PhaseSummary
+	SchedulingDelay    *hdrhistogram.Histogram
+	RequestSizes       *hdrhistogram.Histogram // body sizes, see RequestSizer
+	HeadlineMetric     string
+	OutputJson         bool
 }
 
 // Struct and functions for sorting errors
@@ -42,19 +56,76 @@ func (p ErrorList) Len() int           { return len(p) }
 func (p ErrorList) Less(i, j int) bool { return p[i].Count < p[j].Count }
 func (p ErrorList) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
 
+// requestTotal returns the number of completed requests, including failed and
+// slow ones.
+func (s *Summary) requestTotal() uint64 {
+	return s.SuccessTotal + s.ErrorTotal + s.SlowTotal
+}
+
+func (s *Summary) successRate() float64 {
+	requestTotal := s.requestTotal()
+	if requestTotal == 0 {
+		return 0
+	}
+	return float64(s.SuccessTotal) / float64(requestTotal) * 100
+}
+
+// ValidHeadlineMetric reports whether metric can be used as the
+// HeadlineMetric: mean, median or a percentile like p95, p99 or p99.9.
+func ValidHeadlineMetric(metric string) bool {
+	_, ok := headlinePercentile(metric)
+	return ok || metric == "" || metric == "mean"
+}
+
+func headlinePercentile(metric string) (float64, bool) {
+	if metric == "median" {
+		return 50, true
+	}
+	if !strings.HasPrefix(metric, "p") {
+		return 0, false
+	}
+	percentile, err := strconv.ParseFloat(metric[1:], 64)
+	if err != nil || percentile <= 0 || percentile > 100 {
+		return 0, false
+	}
+	return percentile, true
+}
+
+// headline returns the name and the value in ms of the latency metric shown
+// in the one-line summary, AvgRequestTime by default.
+func (s *Summary) headline() (string, float64) {
+	percentile, ok := headlinePercentile(s.HeadlineMetric)
+	if !ok {
+		return "AvgRequestTime", s.AvgRequestTime
+	}
+	name := "P" + strconv.FormatFloat(percentile, 'f', -1, 64) + "RequestTime"
+	return name, float64(s.SuccessHistogram.ValueAtQuantile(percentile)) / 1000000
+}
+
+// Saturated reports whether the load generator was saturated during the run,
+// based on how late its goroutines were scheduled.
+func (s *Summary) Saturated() bool {
+	return s.SchedulingDelay != nil && s.SchedulingDelay.TotalCount() > 0 &&
+		s.SchedulingDelay.ValueAtQuantile(99) >= saturationSchedulingDelay.Nanoseconds()
+}
+
 // String returns a stringified version of the Summary.
 func (s *Summary) String() string {
-	requestTotal := s.SuccessTotal + s.ErrorTotal
-	successRate := 0.
-	if requestTotal > 0 {
-		successRate = float64(s.SuccessTotal) / float64(requestTotal) * 100
-	}
+	requestTotal := s.requestTotal()
+	successRate := s.successRate()
+	headlineName, headlineValue := s.headline()
 
 	var outputBuffer bytes.Buffer
 
 	fmt.Fprintf(&outputBuffer,
-		"\n{SuccessRate: %.2f%%, Throughput: %.2f req/s, AvgRequestTime: %.2f ms, Connections: %d, RequestRate: %.0f, RequestTotal: %d, SuccessTotal: %d, ErrorTotal: %d, TimeElapsed: %s}\n",
-		successRate, s.Throughput, s.AvgRequestTime, s.Connections, s.RequestRate, requestTotal, s.SuccessTotal, s.ErrorTotal, s.TimeElapsed)
+		"\n{SuccessRate: %.2f%%, Throughput: %.2f req/s, %s: %.2f ms, Connections: %d, RequestRate: %.0f, RequestTotal: %d, SuccessTotal: %d, ErrorTotal: %d, TimeElapsed: %s}\n",
+		successRate, s.Throughput, headlineName, headlineValue, s.Connections, s.RequestRate, requestTotal, s.SuccessTotal, s.ErrorTotal, s.TimeElapsed)
+
+	if s.Saturated() {
+		fmt.Fprintf(&outputBuffer,
+			"\nWARNING! The load generator appears saturated: P99 scheduling delay of its goroutines is %.2f ms.\nLatency may reflect contention in the generator rather than the server, consider fewer Clients or more CPUs.\n",
+			float64(s.SchedulingDelay.ValueAtQuantile(99))/1000000)
+	}
 
 	if s.OutputJson {
 		// Serializing Summary object into JSON
@@ -68,22 +139,108 @@ func (s *Summary) String() string {
 
 	metricsTable := tablewriter.NewWriter(&outputBuffer)
 	metricsTable.SetHeader([]string{"Metric", "Absolute", "Percentage %"})
-
-	//Printing metric data as a table
-	metricsTable.Append([]string{"Total Requests", strconv.FormatUint(requestTotal, 10), ""})
-	metricsTable.Append([]string{"Successful Requests", strconv.FormatUint(s.SuccessTotal, 10), strconv.FormatFloat(successRate, 'f', 2, 64)})
-	metricsTable.Append([]string{"Failed Requests", strconv.FormatUint(s.ErrorTotal, 10), strconv.FormatFloat(100-successRate, 'f', 2, 64)})
-	metricsTable.Append([]string{"Time Elapsed (sec)", strconv.FormatFloat(s.TimeElapsed.Seconds(), 'f', 2, 64), ""})
-	metricsTable.Append([]string{"Request Rate (req/sec)", strconv.FormatFloat(s.RequestRate, 'f', 2, 64), ""})
-	metricsTable.Append([]string{"Throughput (req/sec)", strconv.FormatFloat(s.Throughput, 'f', 2, 64), ""})
-	metricsTable.Append([]string{"AvgRequestTime (ms)", strconv.FormatFloat(s.AvgRequestTime, 'f', 2, 64), ""})
-	metricsTable.Append([]string{"Timely Ticks", strconv.FormatUint(s.TicksTimely, 10), strconv.FormatFloat(s.TicksTimelyRatio, 'f', 2, 64)})
-	metricsTable.Append([]string{"Timely Sends", strconv.FormatUint(s.SendsTimely, 10), strconv.FormatFloat(s.SendsTimelyRatio, 'f', 2, 64)})
+	metricsTable.SetAutoWrapText(false)
+	metricsTable.AppendBulk(s.metricsRows())
 
 	//Printing error results as a table
 	//Laying out headers and values
 	errorTable := tablewriter.NewWriter(&outputBuffer)
 	errorTable.SetHeader([]string{"Error", "Absolute", "Percentage %"})
+	errorRows := s.errorRows()
+	errorTable.AppendBulk(errorRows)
+
+	outputBuffer.WriteString("\n")
+	metricsTable.Render()
+
+	if len(s.Phases) > 0 {
+		outputBuffer.WriteString("\n")
+		outputBuffer.WriteString(s.phasesTable())
+	}
+
+	if len(errorRows) > 0 {
+		outputBuffer.WriteString("\n")
+		errorTable.Render()
+	}
+
+	return outputBuffer.String()
+}
+
+// metricsRows returns the rows of the metrics table: metric, absolute value
+// and percentage.
+func (s *Summary) metricsRows() [][]string {
+	requestTotal := s.requestTotal()
+	successRate := s.successRate()
+
+	var rows [][]string
+	rows = append(rows, []string{"Total Requests", strconv.FormatUint(requestTotal, 10), ""})
+	rows = append(rows, []string{"Successful Requests", strconv.FormatUint(s.SuccessTotal, 10), strconv.FormatFloat(successRate, 'f', 2, 64)})
+	if s.SlowTotal > 0 {
+		slowRate := float64(s.SlowTotal) / float64(requestTotal) * 100
+		rows = append(rows, []string{"Failed Requests", strconv.FormatUint(s.ErrorTotal, 10), strconv.FormatFloat(100-successRate-slowRate, 'f', 2, 64)})
+		rows = append(rows, []string{"Slow Requests (cancelled)", strconv.FormatUint(s.SlowTotal, 10), strconv.FormatFloat(slowRate, 'f', 2, 64)})
+	} else {
+		rows = append(rows, []string{"Failed Requests", strconv.FormatUint(s.ErrorTotal, 10), strconv.FormatFloat(100-successRate, 'f', 2, 64)})
+	}
+	if s.DroppedTotal > 0 {
+		rows = append(rows, []string{"Dropped at Shutdown", strconv.FormatUint(s.DroppedTotal, 10), ""})
+	}
+	if s.RateLimited > 0 {
+		rows = append(rows, []string{"Skipped by Rate Limit", strconv.FormatUint(s.RateLimited, 10), ""})
+	}
+	rows = append(rows, []string{"Time Elapsed (sec)", strconv.FormatFloat(s.TimeElapsed.Seconds(), 'f', 2, 64), ""})
+	rows = append(rows, []string{"Request Rate (req/sec)", strconv.FormatFloat(s.RequestRate, 'f', 2, 64), ""})
+	if s.PerConnectionRate && s.Connections > 0 {
+		rows = append(rows, []string{"Rate per Connection (req/sec)", strconv.FormatFloat(s.RequestRate/float64(s.Connections), 'f', 2, 64), ""})
+	}
+	rows = append(rows, []string{"Throughput (req/sec)", strconv.FormatFloat(s.Throughput, 'f', 2, 64), ""})
+	rows = append(rows, []string{"AvgRequestTime (ms)", strconv.FormatFloat(s.AvgRequestTime, 'f', 2, 64), ""})
+	if s.CorrectedHistogram != nil && s.SuccessHistogram.TotalCount() > 0 {
+		impact := float64(s.CorrectedHistogram.ValueAtQuantile(99)-s.SuccessHistogram.ValueAtQuantile(99)) / 1000000
+		// The backfilled samples are lower than the measured one, so they can
+		// shift the percentile slightly down when there's no real impact
+		if impact < 0 {
+			impact = 0
+		}
+		rows = append(rows, []string{"Coordinated Omission Impact P99 (ms)", strconv.FormatFloat(impact, 'f', 2, 64), ""})
+	}
+	rows = append(rows, []string{"Timely Ticks", strconv.FormatUint(s.TicksTimely, 10), strconv.FormatFloat(s.TicksTimelyRatio, 'f', 2, 64)})
+	rows = append(rows, []string{"Timely Sends", strconv.FormatUint(s.SendsTimely, 10), strconv.FormatFloat(s.SendsTimelyRatio, 'f', 2, 64)})
+	if s.SendDelayHistogram != nil && s.SendDelayHistogram.TotalCount() > 0 {
+		for _, percentile := range []float64{50, 99, 99.9, 100} {
+			name := "Send Delay P" + strconv.FormatFloat(percentile, 'f', -1, 64) + " (ms)"
+			if percentile == 100 {
+				name = "Send Delay Max (ms)"
+			}
+			delay := float64(s.SendDelayHistogram.ValueAtQuantile(percentile)) / 1000000
+			rows = append(rows, []string{name, strconv.FormatFloat(delay, 'f', 3, 64), ""})
+		}
+	}
+	if s.RequestSizes != nil && s.RequestSizes.TotalCount() > 0 {
+		rows = append(rows, []string{"Request Size Mean (bytes)", strconv.FormatFloat(s.RequestSizes.Mean(), 'f', 0, 64), ""})
+		for _, percentile := range []float64{50, 90, 99, 100} {
+			name := "Request Size P" + strconv.FormatFloat(percentile, 'f', -1, 64) + " (bytes)"
+			if percentile == 100 {
+				name = "Request Size Max (bytes)"
+			}
+			rows = append(rows, []string{name, strconv.FormatInt(s.RequestSizes.ValueAtQuantile(percentile), 10), ""})
+		}
+	}
+	if s.SchedulingDelay != nil && s.SchedulingDelay.TotalCount() > 0 {
+		rows = append(rows, []string{"Generator Scheduling Delay P99 (ms)", strconv.FormatFloat(float64(s.SchedulingDelay.ValueAtQuantile(99))/1000000, 'f', 3, 64), ""})
+	}
+	rows = append(rows, []string{"Generator GC Count", strconv.FormatUint(uint64(s.GCCount), 10), ""})
+	if s.GCCount > 0 {
+		rows = append(rows, []string{"Generator GC Pause Total (ms)", strconv.FormatFloat(s.GCPauseTotal.Seconds()*1000, 'f', 3, 64), ""})
+		rows = append(rows, []string{"Generator GC Pause Max (ms)", strconv.FormatFloat(s.GCPauseMax.Seconds()*1000, 'f', 3, 64), ""})
+	}
+
+	return rows
+}
+
+// errorRows returns the rows of the errors table sorted by highest count:
+// error, absolute count and percentage of all requests.
+func (s *Summary) errorRows() [][]string {
+	requestTotal := s.requestTotal()
 
 	//Sorting errors by highest count
 	el := make(ErrorList, len(s.Errors))
@@ -95,20 +252,13 @@ func (s *Summary) String() string {
 	sort.Sort(sort.Reverse(el)) //Sort in descending order
 
 	//Loop through each Error and print count
+	rows := make([][]string, 0, len(el))
 	for _, err := range el {
 		percentage := float64(err.Count) / float64(requestTotal) * 100
-		errorTable.Append([]string{err.ErrorCode, strconv.Itoa(err.Count), strconv.FormatFloat(percentage, 'f', 2, 64)})
+		rows = append(rows, []string{err.ErrorCode, strconv.Itoa(err.Count), strconv.FormatFloat(percentage, 'f', 2, 64)})
 	}
 
-	outputBuffer.WriteString("\n")
-	metricsTable.Render()
-
-	if el.Len() > 0 {
-		outputBuffer.WriteString("\n")
-		errorTable.Render()
-	}
-
-	return outputBuffer.String()
+	return rows
 }
 
 // GenerateLatencyDistribution generates a text file containing the specified
@@ -116,14 +266,18 @@ func (s *Summary) String() string {
 // http://hdrhistogram.github.io/HdrHistogram/plotFiles.html. Percentiles is a
 // list of percentiles to include, e.g. 10.0, 50.0, 99.0, 99.99, etc. If
 // percentiles is nil, it defaults to a logarithmic percentile scale. If a
-// request rate was specified for the benchmark, this will also generate an
-// uncorrected distribution file which does not account for coordinated
-// omission.
+// request rate was specified for the benchmark, this will also generate a
+// distribution file corrected for coordinated omission, which accounts for the
+// requests not sent while waiting for slow responses. If the benchmark had
+// Phases, a distribution file is generated for every phase too.
 func (s *Summary) GenerateLatencyDistribution(percentiles Percentiles, file string) error {
-	return generateLatencyDistribution(s.SuccessHistogram, nil, s.RequestRate, percentiles, file)
+	if err := generateLatencyDistribution(s.SuccessHistogram, s.CorrectedHistogram, s.RequestRate, percentiles, file); err != nil {
+		return err
+	}
+	return s.generatePhaseDistributions(percentiles, file)
 }
 
-func generateLatencyDistribution(histogram, unHistogram *hdrhistogram.Histogram, requestRate float64, percentiles Percentiles, file string) error {
+func generateLatencyDistribution(histogram, coHistogram *hdrhistogram.Histogram, requestRate float64, percentiles Percentiles, file string) error {
 	if percentiles == nil {
 		percentiles = Logarithmic
 	}
@@ -143,9 +297,9 @@ func generateLatencyDistribution(histogram, unHistogram *hdrhistogram.Histogram,
 		}
 	}
 
-	// Generate uncorrected distribution.
-	if requestRate > 0 && unHistogram != nil {
-		f, err := os.Create(file + ".uncorrected")
+	// Generate corrected distribution.
+	if requestRate > 0 && coHistogram != nil {
+		f, err := os.Create(file + ".corrected")
 		if err != nil {
 			return err
 		}
@@ -153,7 +307,7 @@ func generateLatencyDistribution(histogram, unHistogram *hdrhistogram.Histogram,
 
 		f.WriteString("Value    Percentile    TotalCount    1/(1-Percentile)\n\n")
 		for _, percentile := range percentiles {
-			value := float64(unHistogram.ValueAtQuantile(percentile)) / 1000000
+			value := float64(coHistogram.ValueAtQuantile(percentile)) / 1000000
 			_, err := f.WriteString(fmt.Sprintf("%f    %f        %d            %f\n",
 				value, percentile/100, 0, 1/(1-(percentile/100))))
 			if err != nil {
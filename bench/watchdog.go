@@ -0,0 +1,81 @@
+package bench
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/codahale/hdrhistogram"
+)
+
+const (
+	watchdogInterval = 10 * time.Millisecond
+
+	// The generator is considered saturated when its goroutines wait this long
+	// to be scheduled
+	saturationSchedulingDelay = 2 * time.Millisecond
+
+	// Number of idle waits measuring the lateness of the timer
+	timerCalibrationSamples = 5
+)
+
+var (
+	timerLatenessOnce sync.Once
+	timerLateness     time.Duration
+)
+
+// idleTimerLateness returns how late the watchdog's timer fires while the
+// generator is idle, measured once per process before the first run. Coarse
+// system timers, e.g. 15.6ms by default on Windows, wake every goroutine up
+// late regardless of the load, which mustn't be taken for saturation.
+func idleTimerLateness() time.Duration {
+	timerLatenessOnce.Do(func() {
+		for i := 0; i < timerCalibrationSamples; i++ {
+			expected := time.Now().Add(watchdogInterval)
+			time.Sleep(watchdogInterval)
+			if late := time.Since(expected); late > timerLateness {
+				timerLateness = late
+			}
+		}
+		if timerLateness >= saturationSchedulingDelay {
+			fmt.Fprintf(os.Stderr, "Coarse timer resolution: timers fire up to %v late when idle, this isn't counted as scheduling delay\n", timerLateness.Round(time.Microsecond))
+		}
+	})
+	return timerLateness
+}
+
+// watchdogFunc measures how late a goroutine wakes up from sleep during the
+// measurement. When the generator's CPU is saturated, goroutines wait in the
+// run queue, so requests are sent late and their latency includes the wait.
+// The lateness of the idle timer is the baseline, only the delay beyond it is
+// recorded.
+func (b *Benchmark) watchdogFunc(baseline time.Duration, measuring <-chan struct{}, tickerDone <-chan struct{}) {
+	b.schedulingDelay = hdrhistogram.New(minRecordableSendDelayNS, maxRecordableLatencyNS, sendDelaySigFigs)
+
+	select {
+	case <-measuring:
+	case <-tickerDone:
+		return
+	}
+
+	timer := time.NewTimer(watchdogInterval)
+	defer timer.Stop()
+
+	expected := time.Now().Add(watchdogInterval)
+	for {
+		select {
+		case <-timer.C:
+			delay := time.Since(expected) - baseline
+			if delay < 0 {
+				delay = 0
+			}
+			_ = b.schedulingDelay.RecordValue(delay.Nanoseconds())
+
+			timer.Reset(watchdogInterval)
+			expected = time.Now().Add(watchdogInterval)
+		case <-tickerDone:
+			return
+		}
+	}
+}
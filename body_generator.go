@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// bodyField describes a single field of a generated JSON request body.
+type bodyField struct {
+	Name string `yaml:"Name"`
+
+	// Type is one of: int, float, string, bool, enum, uuid, timestamp, object, array
+	Type string `yaml:"Type"`
+
+	// Value range for int and float
+	Min float64 `yaml:"Min"`
+	Max float64 `yaml:"Max"`
+
+	// Length range for string and array
+	MinLength int `yaml:"MinLength"`
+	MaxLength int `yaml:"MaxLength"`
+
+	// Values to pick from for enum
+	Values []interface{} `yaml:"Values"`
+
+	// Fields of an object
+	Fields []bodyField `yaml:"Fields"`
+
+	// Items of an array
+	Items *bodyField `yaml:"Items"`
+}
+
+const bodyGeneratorLetters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+// validateBodyFields checks the generator config, so mistakes are reported
+// at startup rather than during the run.
+func validateBodyFields(fields []bodyField) error {
+	for _, field := range fields {
+		if err := field.validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *bodyField) validate() error {
+	switch f.Type {
+	case "int", "float":
+		if f.Max < f.Min {
+			return fmt.Errorf("BodyGenerator field %q: Max must not be less than Min", f.Name)
+		}
+	case "string", "array":
+		if f.MaxLength < f.MinLength {
+			return fmt.Errorf("BodyGenerator field %q: MaxLength must not be less than MinLength", f.Name)
+		}
+		if f.Type == "array" {
+			if f.Items == nil {
+				return fmt.Errorf("BodyGenerator field %q: Items must be specified for array", f.Name)
+			}
+			return f.Items.validate()
+		}
+	case "enum":
+		if len(f.Values) == 0 {
+			return fmt.Errorf("BodyGenerator field %q: Values must be specified for enum", f.Name)
+		}
+		for i, v := range f.Values {
+			f.Values[i] = jsonCompatible(v)
+		}
+	case "object":
+		return validateBodyFields(f.Fields)
+	case "bool", "uuid", "timestamp":
+	default:
+		return fmt.Errorf("BodyGenerator field %q: unknown type %q", f.Name, f.Type)
+	}
+	return nil
+}
+
+// generateBody returns a new JSON object with the given fields, preserving
+// their order.
+func generateBody(rng *rand.Rand, fields []bodyField) string {
+	return string(appendObject(nil, rng, fields))
+}
+
+func appendObject(buf []byte, rng *rand.Rand, fields []bodyField) []byte {
+	buf = append(buf, '{')
+	for i := range fields {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		buf = appendJSON(buf, fields[i].Name)
+		buf = append(buf, ':')
+		buf = fields[i].appendValue(buf, rng)
+	}
+	return append(buf, '}')
+}
+
+func (f *bodyField) appendValue(buf []byte, rng *rand.Rand) []byte {
+	switch f.Type {
+	case "int":
+		return appendJSON(buf, int64(f.Min)+rng.Int63n(int64(f.Max)-int64(f.Min)+1))
+	case "float":
+		return appendJSON(buf, f.Min+rng.Float64()*(f.Max-f.Min))
+	case "string":
+		s := make([]byte, f.randomLength(rng))
+		for i := range s {
+			s[i] = bodyGeneratorLetters[rng.Intn(len(bodyGeneratorLetters))]
+		}
+		return appendJSON(buf, string(s))
+	case "bool":
+		return appendJSON(buf, rng.Intn(2) == 1)
+	case "enum":
+		return appendJSON(buf, f.Values[rng.Intn(len(f.Values))])
+	case "uuid":
+		return appendJSON(buf, newUUID(rng))
+	case "timestamp":
+		return appendJSON(buf, time.Now().UTC().Format(time.RFC3339Nano))
+	case "object":
+		return appendObject(buf, rng, f.Fields)
+	case "array":
+		buf = append(buf, '[')
+		for i, n := 0, f.randomLength(rng); i < n; i++ {
+			if i > 0 {
+				buf = append(buf, ',')
+			}
+			buf = f.Items.appendValue(buf, rng)
+		}
+		return append(buf, ']')
+	}
+	return append(buf, "null"...)
+}
+
+// newUUID returns a random (version 4) UUID.
+func newUUID(rng *rand.Rand) string {
+	u := make([]byte, 16)
+	_, _ = rng.Read(u)
+	u[6] = (u[6] & 0x0f) | 0x40 // version 4
+	u[8] = (u[8] & 0x3f) | 0x80 // variant 10
+	h := hex.EncodeToString(u)
+	return h[0:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:]
+}
+
+func (f *bodyField) randomLength(rng *rand.Rand) int {
+	return f.MinLength + rng.Intn(f.MaxLength-f.MinLength+1)
+}
+
+func appendJSON(buf []byte, v interface{}) []byte {
+	b, err := json.Marshal(v)
+	maybePanic(err)
+	return append(buf, b...)
+}
+
+// jsonCompatible converts maps produced by the YAML decoder into maps which
+// can be marshaled to JSON.
+func jsonCompatible(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, val := range v {
+			m[fmt.Sprint(key)] = jsonCompatible(val)
+		}
+		return m
+	case []interface{}:
+		for i := range v {
+			v[i] = jsonCompatible(v[i])
+		}
+	}
+	return v
+}
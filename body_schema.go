@@ -0,0 +1,55 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// validateBody validates the configured request body against BodySchema.
+// When BodyGenerator is used, a sample body is validated.
+func (w *WebRequesterFactory) validateBody() error {
+	body := w.Body
+	if w.BodyGenerator != nil {
+		body = generateBody(rand.New(rand.NewSource(time.Now().UnixNano())), w.BodyGenerator)
+	} else if w.BodyFile != "" {
+		content, err := ioutil.ReadFile(w.BodyFile)
+		if err != nil {
+			return err
+		}
+		body = string(content)
+	}
+
+	return validateBodySchema(w.BodySchema, body)
+}
+
+// validateBodySchema validates the request body against the JSON Schema in
+// the given file, so malformed bodies are caught before the run starts.
+func validateBodySchema(schemaFile string, body string) error {
+	schemaPath, err := filepath.Abs(schemaFile)
+	if err != nil {
+		return err
+	}
+
+	schema := gojsonschema.NewReferenceLoader("file://" + filepath.ToSlash(schemaPath))
+	result, err := gojsonschema.Validate(schema, gojsonschema.NewStringLoader(body))
+	if err != nil {
+		return fmt.Errorf("failed to validate body against %s: %v", schemaFile, err)
+	}
+
+	if !result.Valid() {
+		violations := make([]string, 0, len(result.Errors()))
+		for _, violation := range result.Errors() {
+			violations = append(violations, violation.String())
+		}
+		return errors.New("request body does not match " + schemaFile + ":\n\t" + strings.Join(violations, "\n\t"))
+	}
+
+	return nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+)
+
+// bodySizeDistribution draws request body sizes from a distribution, to
+// mimic the variety of real payload sizes. Bodies are random bytes.
+type bodySizeDistribution struct {
+	// Type is one of: lognormal, exponential, uniform
+	Type string `yaml:"Type"`
+
+	// Mean size in bytes for lognormal and exponential
+	Mean float64 `yaml:"Mean"`
+
+	// Sigma of the underlying normal distribution for lognormal
+	Sigma float64 `yaml:"Sigma"`
+
+	// Sizes are clamped to Min and Max bytes. Max defaults to 10 * Mean.
+	Min int `yaml:"Min"`
+	Max int `yaml:"Max"`
+
+	// random bytes the bodies are sliced from, shared by all requesters
+	pool []byte
+}
+
+// init validates the distribution and generates the random bytes.
+func (d *bodySizeDistribution) init() error {
+	switch d.Type {
+	case "lognormal", "exponential":
+		if d.Mean <= 0 {
+			return fmt.Errorf("BodySizeDistribution: Mean must be positive for %s", d.Type)
+		}
+		if d.Max == 0 {
+			d.Max = int(10 * d.Mean)
+		}
+	case "uniform":
+		if d.Max == 0 {
+			return fmt.Errorf("BodySizeDistribution: Max must be specified for uniform")
+		}
+	default:
+		return fmt.Errorf("BodySizeDistribution: unknown type %q", d.Type)
+	}
+
+	if d.Min < 0 || d.Max < d.Min {
+		return fmt.Errorf("BodySizeDistribution: Max must not be less than Min")
+	}
+
+	// Twice the maximum, so bodies can start at a random offset
+	d.pool = make([]byte, 2*d.Max)
+	_, _ = rand.Read(d.pool)
+	return nil
+}
+
+// body returns a body of a randomly drawn size.
+func (d *bodySizeDistribution) body(rng *rand.Rand) []byte {
+	var size float64
+	switch d.Type {
+	case "lognormal":
+		// mu is chosen so that the mean of the sizes is Mean
+		mu := math.Log(d.Mean) - d.Sigma*d.Sigma/2
+		size = math.Exp(mu + d.Sigma*rng.NormFloat64())
+	case "exponential":
+		size = rng.ExpFloat64() * d.Mean
+	case "uniform":
+		size = float64(d.Min + rng.Intn(d.Max-d.Min+1))
+	}
+
+	n := int(math.Round(size))
+	if n < d.Min {
+		n = d.Min
+	} else if n > d.Max {
+		n = d.Max
+	}
+
+	offset := rng.Intn(d.Max + 1)
+	return d.pool[offset : offset+n]
+}
@@ -1,7 +1,9 @@
 module labench
 
 require (
+	github.com/xeipuuv/gojsonschema v1.2.0
 	golang.org/x/net v0.0.0-20190522155817-f3200d17e092
+	golang.org/x/time v0.0.0-20190308202827-9d24e82272b4
 	gopkg.in/yaml.v2 v2.2.2
 	labench/bench v0.0.0
 )
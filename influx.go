@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"labench/bench"
+)
+
+// influxConfig describes where per-interval metrics are written in InfluxDB
+// line protocol. File and URL can be used together.
+type influxConfig struct {
+	File        string            `yaml:"File"`
+	URL         string            `yaml:"URL"`
+	Measurement string            `yaml:"Measurement"`
+	Tags        map[string]string `yaml:"Tags"`
+}
+
+// influxReporter implements bench.IntervalReporter by converting every
+// interval into a single line of InfluxDB line protocol.
+type influxReporter struct {
+	measurement string
+	tags        string
+	file        *os.File
+	url         string
+	lines       chan string
+	wg          sync.WaitGroup
+}
+
+var influxEscaper = strings.NewReplacer(",", `\,`, " ", `\ `, "=", `\=`)
+
+func newInfluxReporter(conf *influxConfig) (*influxReporter, error) {
+	r := &influxReporter{
+		measurement: conf.Measurement,
+		url:         os.ExpandEnv(conf.URL),
+	}
+	if r.measurement == "" {
+		r.measurement = "labench"
+	}
+
+	keys := make([]string, 0, len(conf.Tags))
+	for key := range conf.Tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		r.tags += "," + influxEscaper.Replace(key) + "=" + influxEscaper.Replace(os.ExpandEnv(conf.Tags[key]))
+	}
+
+	if conf.File != "" {
+		f, err := os.Create(conf.File)
+		if err != nil {
+			return nil, err
+		}
+		r.file = f
+	}
+
+	// Pushing is done asynchronously so that a slow endpoint doesn't stall the results collector
+	if r.url != "" {
+		r.lines = make(chan string, 100)
+		r.wg.Add(1)
+		go r.pusher()
+	}
+
+	return r, nil
+}
+
+// ReportInterval writes the interval metrics to the configured destinations.
+func (r *influxReporter) ReportInterval(stats *bench.IntervalStats) error {
+	line := fmt.Sprintf("%s%s rps=%f,success=%di,errors=%di,p50=%f,p90=%f,p99=%f,p999=%f,max=%f %d\n",
+		influxEscaper.Replace(r.measurement), r.tags,
+		stats.Throughput(), stats.SuccessTotal, stats.ErrorTotal,
+		stats.Percentile(50), stats.Percentile(90), stats.Percentile(99), stats.Percentile(99.9), stats.Percentile(100),
+		stats.Start.Add(stats.Duration).UnixNano())
+
+	// The file gets every line, whatever the state of the push queue
+	if r.file != nil {
+		if _, err := r.file.WriteString(line); err != nil {
+			return err
+		}
+	}
+
+	if r.lines != nil {
+		select {
+		case r.lines <- line:
+		default:
+			return fmt.Errorf("InfluxDB push queue is full, dropping metrics for %v", stats.Start)
+		}
+	}
+
+	return nil
+}
+
+func (r *influxReporter) pusher() {
+	defer r.wg.Done()
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	for line := range r.lines {
+		resp, err := client.Post(r.url, "text/plain; charset=utf-8", bytes.NewBufferString(line))
+		if err != nil {
+			fmt.Println("Failed to push metrics to InfluxDB:", err)
+			continue
+		}
+
+		// #nosec
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		_ = resp.Body.Close()
+
+		if resp.StatusCode/100 != 2 {
+			fmt.Println("Failed to push metrics to InfluxDB:", resp.Status)
+		}
+	}
+}
+
+// Close flushes pending metrics and releases the output file.
+func (r *influxReporter) Close() error {
+	if r.lines != nil {
+		close(r.lines)
+		r.wg.Wait()
+	}
+
+	if r.file != nil {
+		return r.file.Close()
+	}
+
+	return nil
+}
@@ -9,23 +9,60 @@ import (
 	"net/http"
 	"os"
 	"path"
+	"runtime"
+	"runtime/debug"
 	"time"
 
 	"labench/bench"
 
+	"golang.org/x/time/rate"
 	yaml "gopkg.in/yaml.v2"
 )
 
 type benchParams struct {
-	RequestRatePerSec uint64        `yaml:"RequestRatePerSec"`
-	Clients           uint64        `yaml:"Clients"`
-	Duration          time.Duration `yaml:"Duration"`
-	BaseLatency       time.Duration `yaml:"BaseLatency"`
-	RequestTimeout    time.Duration `yaml:"RequestTimeout"`
-	ReuseConnections  bool          `yaml:"ReuseConnections"`
-	DontLinger        bool          `yaml:"DontLinger"`
-	OutputJSON        bool          `yaml:"OutputJSON"`
-	TightTicker       bool          `yaml:"TightTicker"`
+	RequestRatePerSec  uint64            `yaml:"RequestRatePerSec"`
+	GlobalRateLimit    float64           `yaml:"GlobalRateLimit"`
+	RatePerConnection  float64           `yaml:"RatePerConnection"`
+	Clients            uint64            `yaml:"Clients"`
+	ClientsPerCore     float64           `yaml:"ClientsPerCore"`
+	Duration           time.Duration     `yaml:"Duration"`
+	Warmup             time.Duration     `yaml:"Warmup"`
+	GCInterval         time.Duration     `yaml:"GCInterval"`
+	GOGC               *int              `yaml:"GOGC"`
+	BaseLatency        time.Duration     `yaml:"BaseLatency"`
+	HistogramMin       time.Duration     `yaml:"HistogramMin"`
+	RequestTimeout     time.Duration     `yaml:"RequestTimeout"`
+	SlowThreshold      time.Duration     `yaml:"SlowThreshold"`
+	LatencyMetric      string            `yaml:"LatencyMetric"`
+	DrainTimeout       time.Duration     `yaml:"DrainTimeout"`
+	ReuseConnections   bool              `yaml:"ReuseConnections"`
+	DontLinger         bool              `yaml:"DontLinger"`
+	TCPNoDelay         *bool             `yaml:"TCPNoDelay"`
+	MaxConcurrentDials uint64            `yaml:"MaxConcurrentDials"`
+	HostOverrides      map[string]string `yaml:"HostOverrides"`
+	NetworkProfile     *networkProfile   `yaml:"NetworkProfile"`
+	UntilStable        *stabilityConfig  `yaml:"UntilStablePercentile"`
+	Phases             []phaseConfig     `yaml:"Phases"`
+	OutputJSON         bool              `yaml:"OutputJSON"`
+	HeadlineMetric     string            `yaml:"HeadlineMetric"`
+	TightTicker        bool              `yaml:"TightTicker"`
+	ReportInterval     time.Duration     `yaml:"ReportInterval"`
+	CheckpointFile     string            `yaml:"CheckpointFile"`
+	Resume             bool              `yaml:"Resume"`
+	Repeat             uint64            `yaml:"Repeat"`
+	WorkerCPUs         []int             `yaml:"WorkerCPUs"`
+}
+
+type stabilityConfig struct {
+	Percentile      float64       `yaml:"Percentile"`
+	MaxWidthPercent float64       `yaml:"MaxWidthPercent"`
+	SampleInterval  time.Duration `yaml:"SampleInterval"`
+	MinSamples      int           `yaml:"MinSamples"`
+}
+
+type phaseConfig struct {
+	Name     string        `yaml:"Name"`
+	Duration time.Duration `yaml:"Duration"`
 }
 
 type config struct {
@@ -33,6 +70,11 @@ type config struct {
 	Protocol string              `yaml:"Protocol"`
 	Request  WebRequesterFactory `yaml:"Request"`
 	Output   string              `yaml:"OutFile"`
+	InfluxDB *influxConfig       `yaml:"InfluxDB"`
+	HTML     string              `yaml:"HTMLReport"`
+	Hlog     string              `yaml:"HlogFile"`
+	SSE      sseConfig           `yaml:"SSE"`
+	Sweep    *sweepConfig        `yaml:"Sweep"`
 }
 
 func maybePanic(err error) {
@@ -48,19 +90,60 @@ func assert(cond bool, err string) {
 }
 
 func main() {
-	configFile := "labench.yaml"
-	if len(os.Args) > 1 {
-		assert(len(os.Args) == 2, fmt.Sprintf("Usage: %s [config.yaml]\n\tThe default config file name is: %s", os.Args[0], configFile))
-		configFile = os.Args[1]
-	}
+	configFile, overrides, err := parseArgs(os.Args[1:], "labench.yaml")
+	assert(err == nil, fmt.Sprintf("%v\nUsage: %s [config.yaml] [--set Key=Value]...\n\tThe default config file name is: labench.yaml\n\t--set overrides a config field, e.g. --set RequestRatePerSec=5000 --set Request.URL=http://localhost/", err, os.Args[0]))
 
 	configBytes, err := ioutil.ReadFile(configFile)
 	maybePanic(err)
 
+	configBytes, err = applyOverrides(configBytes, overrides)
+	maybePanic(err)
+
 	var conf config
 	err = yaml.Unmarshal(configBytes, &conf)
 	maybePanic(err)
 
+	if conf.Sweep != nil {
+		maybePanic(runSweep(configBytes, conf.Sweep))
+		return
+	}
+
+	runBenchmark(&conf)
+}
+
+// runBenchmark runs the configured benchmark, possibly several times, and
+// writes its reports.
+func runBenchmark(conf *config) *bench.Summary {
+	var err error
+
+	assert(bench.ValidHeadlineMetric(conf.Params.HeadlineMetric), "HeadlineMetric must be one of: mean, median, p95, p99 (or any other pNN percentile)")
+
+	if conf.Request.URLsFile != "" {
+		maybePanic(conf.Request.loadURLsFile())
+	}
+
+	if conf.Request.AuthTokenCommand != nil {
+		maybePanic(conf.Request.AuthTokenCommand.start())
+	}
+
+	if conf.Request.RequestIDLog != "" {
+		assert(conf.Request.RequestIDHeader != "", "RequestIDHeader must be specified when RequestIDLog is used")
+		conf.Request.requestIDLog, err = newRequestIDLog(conf.Request.RequestIDLog, conf.Request.RequestIDLogLatency)
+		maybePanic(err)
+	}
+
+	if conf.Request.BodySizeDistribution != nil {
+		maybePanic(conf.Request.BodySizeDistribution.init())
+	}
+
+	if conf.Request.BodyGenerator != nil {
+		maybePanic(validateBodyFields(conf.Request.BodyGenerator))
+	}
+
+	if conf.Request.BodySchema != "" {
+		maybePanic(conf.Request.validateBody())
+	}
+
 	// fmt.Printf("%+v\n", conf)
 	fmt.Println("timeStart =", time.Now().UTC().Add(-5*time.Second).Truncate(time.Second))
 
@@ -69,7 +152,7 @@ func main() {
 	}
 
 	if conf.Request.HTTPMethod == "" {
-		if conf.Request.Body == "" && conf.Request.BodyFile == "" {
+		if conf.Request.Body == "" && conf.Request.BodyFile == "" && conf.Request.BodyGenerator == nil && conf.Request.BodySizeDistribution == nil {
 			conf.Request.HTTPMethod = http.MethodGet
 		} else {
 			conf.Request.HTTPMethod = http.MethodPost
@@ -84,16 +167,65 @@ func main() {
 
 	switch conf.Protocol {
 	case "HTTP/2":
-		initHTTP2Client(conf.Params.RequestTimeout, conf.Params.DontLinger)
+		initHTTP2Client(&conf.Params)
+
+	case "SSE":
+		maybePanic(conf.SSE.validate())
+		initHTTPClient(&conf.Params)
+		// Streams are read for longer than a request, only their events time out
+		httpClient.Timeout = 0
 
 	default:
-		initHTTPClient(conf.Params.ReuseConnections, conf.Params.RequestTimeout, conf.Params.DontLinger)
+		initHTTPClient(&conf.Params)
 	}
 
 	if conf.Params.RequestTimeout == 0 {
 		conf.Params.RequestTimeout = 10 * time.Second
 	}
 
+	if len(conf.Params.Phases) > 0 {
+		var total time.Duration
+		names := make(map[string]bool)
+		for i, phase := range conf.Params.Phases {
+			if phase.Name == "" {
+				conf.Params.Phases[i].Name = fmt.Sprintf("phase%d", i+1)
+			}
+			assert(!names[conf.Params.Phases[i].Name], "Phase names must be unique")
+			names[conf.Params.Phases[i].Name] = true
+			total += phase.Duration
+		}
+		if conf.Params.Duration == 0 {
+			conf.Params.Duration = total
+		}
+		assert(total == conf.Params.Duration, "Phases must add up to Duration")
+	}
+
+	conf.Request.slowThreshold = conf.Params.SlowThreshold
+
+	latencyMetric := conf.Params.LatencyMetric
+	assert(latencyMetric == "" || latencyMetric == "total" || latencyMetric == "ttfb", "LatencyMetric must be total or ttfb")
+	conf.Request.ttfb = latencyMetric == "ttfb"
+
+	if conf.Params.GOGC != nil {
+		debug.SetGCPercent(*conf.Params.GOGC)
+	}
+
+	if conf.Params.ClientsPerCore > 0 {
+		assert(conf.Params.Clients == 0, "Clients and ClientsPerCore are mutually exclusive")
+		conf.Params.Clients = uint64(math.Ceil(conf.Params.ClientsPerCore * float64(runtime.NumCPU())))
+		fmt.Printf("Clients: %d (%v per core, %d cores)\n", conf.Params.Clients, conf.Params.ClientsPerCore, runtime.NumCPU())
+	}
+
+	if conf.Params.RatePerConnection > 0 {
+		assert(conf.Params.RequestRatePerSec == 0, "RequestRatePerSec and RatePerConnection are mutually exclusive")
+		assert(conf.Params.Clients > 0, "Clients must be specified when RatePerConnection is used")
+		rate := conf.Params.RatePerConnection * float64(conf.Params.Clients)
+		// A rate of 0 would run the benchmark closed-loop instead
+		assert(rate >= 1, "RatePerConnection * Clients must be at least 1 request per second")
+		conf.Params.RequestRatePerSec = uint64(math.Round(rate))
+		fmt.Println("Effective RequestRatePerSec:", conf.Params.RequestRatePerSec)
+	}
+
 	if conf.Params.Clients == 0 {
 		clients := conf.Params.RequestRatePerSec * uint64(math.Ceil(conf.Params.RequestTimeout.Seconds()))
 		clients += clients / 5 // add 20%
@@ -101,12 +233,131 @@ func main() {
 		fmt.Println("Clients:", clients)
 	}
 
-	benchmark := bench.NewBenchmark(&conf.Request, conf.Params.RequestRatePerSec, conf.Params.Clients, conf.Params.Duration, conf.Params.BaseLatency)
-	summary, err := benchmark.Run(conf.Params.OutputJSON, conf.Params.TightTicker)
-	maybePanic(err)
+	var reporters []bench.IntervalReporter
+
+	var influx *influxReporter
+	if conf.InfluxDB != nil {
+		influx, err = newInfluxReporter(conf.InfluxDB)
+		maybePanic(err)
+		reporters = append(reporters, influx)
+	}
+
+	var htmlReport *bench.HTMLReport
+	if conf.HTML != "" {
+		htmlReport = bench.NewHTMLReport()
+		reporters = append(reporters, htmlReport)
+	}
+
+	var hlog *bench.HlogWriter
+	if conf.Hlog != "" {
+		maybePanic(os.MkdirAll(path.Dir(conf.Hlog), os.ModeDir|os.ModePerm))
+		hlog, err = bench.NewHlogWriter(conf.Hlog)
+		maybePanic(err)
+		reporters = append(reporters, hlog)
+	}
+
+	var resumeFrom *bench.Checkpoint
+	if conf.Params.CheckpointFile != "" {
+		if conf.Params.Resume {
+			resumeFrom, err = bench.LoadCheckpoint(conf.Params.CheckpointFile)
+			if os.IsNotExist(err) {
+				fmt.Println("Checkpoint", conf.Params.CheckpointFile, "not found, starting from scratch")
+			} else {
+				maybePanic(err)
+				fmt.Printf("Resuming from checkpoint %s: %d requests over %v\n", conf.Params.CheckpointFile, resumeFrom.SuccessTotal+resumeFrom.ErrorTotal, resumeFrom.TimeElapsed)
+			}
+		}
+
+		err = os.MkdirAll(path.Dir(conf.Params.CheckpointFile), os.ModeDir|os.ModePerm)
+		maybePanic(err)
+		reporters = append(reporters, bench.NewCheckpointer(conf.Params.CheckpointFile, resumeFrom))
+	}
+
+	repeat := conf.Params.Repeat
+	if repeat == 0 {
+		repeat = 1
+	}
+
+	// Shared by all benchmarks, so their combined rate never exceeds the limit
+	var rateLimiter *rate.Limiter
+	if conf.Params.GlobalRateLimit > 0 {
+		// Allow bursts of 100ms worth of requests, otherwise ticks not aligned
+		// with the limiter would bring the rate well below the limit
+		burst := int(math.Ceil(conf.Params.GlobalRateLimit / 10))
+		rateLimiter = rate.NewLimiter(rate.Limit(conf.Params.GlobalRateLimit), burst)
+	}
+
+	var factory bench.RequesterFactory = &conf.Request
+	if conf.Protocol == "SSE" {
+		factory = &sseRequesterFactory{&conf.Request, conf.SSE, conf.Params.RequestTimeout}
+	}
+
+	summaries := make([]*bench.Summary, 0, repeat)
+	for i := uint64(0); i < repeat; i++ {
+		if repeat > 1 {
+			fmt.Printf("\nRun %d of %d\n", i+1, repeat)
+		}
+
+		benchmark := bench.NewBenchmark(factory, conf.Params.RequestRatePerSec, conf.Params.Clients, conf.Params.Duration, conf.Params.BaseLatency)
+		benchmark.ReportInterval = conf.Params.ReportInterval
+		benchmark.IntervalReporters = reporters
+		benchmark.DrainTimeout = conf.Params.DrainTimeout
+		benchmark.WorkerCPUs = conf.Params.WorkerCPUs
+		benchmark.PerConnectionRate = conf.Params.RatePerConnection > 0
+		benchmark.Warmup = conf.Params.Warmup
+		benchmark.GCInterval = conf.Params.GCInterval
+		benchmark.HistogramMin = conf.Params.HistogramMin
+		benchmark.RateLimiter = rateLimiter
+		for _, phase := range conf.Params.Phases {
+			benchmark.Phases = append(benchmark.Phases, bench.Phase{Name: phase.Name, Duration: phase.Duration})
+		}
+		if stability := conf.Params.UntilStable; stability != nil {
+			benchmark.UntilStable = &bench.StabilityCriterion{
+				Percentile:      stability.Percentile,
+				MaxWidthPercent: stability.MaxWidthPercent,
+				SampleInterval:  stability.SampleInterval,
+				MinSamples:      stability.MinSamples,
+			}
+		}
+		benchmark.HeadlineMetric = conf.Params.HeadlineMetric
+
+		summary, err := benchmark.Run(conf.Params.OutputJSON, conf.Params.TightTicker)
+		maybePanic(err)
+
+		if repeat > 1 {
+			fmt.Println(summary)
+		}
+		summaries = append(summaries, summary)
+	}
+
+	summary := summaries[0]
+	if repeat > 1 {
+		summary = bench.Aggregate(summaries)
+		fmt.Println("\nAll runs:")
+		fmt.Println(bench.RepeatsTable(summaries))
+	}
+
+	if resumeFrom != nil {
+		summary.Resume(resumeFrom)
+	}
+
+	if influx != nil {
+		maybePanic(influx.Close())
+	}
+
+	if hlog != nil {
+		maybePanic(hlog.Close())
+	}
+
+	if conf.Request.requestIDLog != nil {
+		maybePanic(conf.Request.requestIDLog.Close())
+	}
 
 	fmt.Println("timeEnd   =", time.Now().UTC().Add(5*time.Second).Round(time.Second))
 
+	if repeat > 1 {
+		fmt.Println("Aggregate of all runs:")
+	}
 	fmt.Println(summary)
 
 	outfile := conf.Output
@@ -119,4 +370,14 @@ func main() {
 
 	err = summary.GenerateLatencyDistribution(bench.Logarithmic, outfile)
 	maybePanic(err)
+
+	if htmlReport != nil {
+		err = os.MkdirAll(path.Dir(conf.HTML), os.ModeDir|os.ModePerm)
+		maybePanic(err)
+
+		err = htmlReport.Generate(summary, conf.HTML)
+		maybePanic(err)
+	}
+
+	return summary
 }
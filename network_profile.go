@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// networkProfile emulates client network conditions, e.g. mobile clients,
+// on every connection of the benchmark.
+type networkProfile struct {
+	// BandwidthKbps limits each direction of every connection, in kbit/s
+	BandwidthKbps float64 `yaml:"BandwidthKbps"`
+
+	// Latency is added to every write, Jitter is the maximum random
+	// deviation from it
+	Latency time.Duration `yaml:"Latency"`
+	Jitter  time.Duration `yaml:"Jitter"`
+
+	// Loss is the probability of a write being lost. TCP retransmits lost
+	// segments, so a lost write is delayed by LossDelay (defaults to 200ms,
+	// the minimum retransmission timeout on Linux).
+	Loss      float64       `yaml:"Loss"`
+	LossDelay time.Duration `yaml:"LossDelay"`
+}
+
+// wrap returns the connection with the profile applied.
+func (p *networkProfile) wrap(con net.Conn) net.Conn {
+	c := &throttledConn{
+		Conn:    con,
+		profile: p,
+		rng:     rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+
+	if p.BandwidthKbps > 0 {
+		bytesPerSec := p.BandwidthKbps * 1000 / 8
+		// Bursts of up to 10ms worth of data
+		burst := int(bytesPerSec / 100)
+		if burst < 1 {
+			burst = 1
+		}
+		c.readLimiter = rate.NewLimiter(rate.Limit(bytesPerSec), burst)
+		c.writeLimiter = rate.NewLimiter(rate.Limit(bytesPerSec), burst)
+	}
+
+	return c
+}
+
+// throttledConn applies a networkProfile to reads and writes.
+type throttledConn struct {
+	net.Conn
+	profile      *networkProfile
+	readLimiter  *rate.Limiter
+	writeLimiter *rate.Limiter
+
+	mu  sync.Mutex // guards rng, HTTP/2 may write from several goroutines
+	rng *rand.Rand
+}
+
+func (c *throttledConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 && c.readLimiter != nil {
+		waitN(c.readLimiter, n)
+	}
+	return n, err
+}
+
+func (c *throttledConn) Write(b []byte) (int, error) {
+	time.Sleep(c.writeDelay())
+	if c.writeLimiter != nil {
+		waitN(c.writeLimiter, len(b))
+	}
+	return c.Conn.Write(b)
+}
+
+func (c *throttledConn) writeDelay() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delay := c.profile.Latency
+	if c.profile.Jitter > 0 {
+		delay += time.Duration(c.rng.Int63n(2*int64(c.profile.Jitter)+1)) - c.profile.Jitter
+	}
+	if c.profile.Loss > 0 && c.rng.Float64() < c.profile.Loss {
+		lossDelay := c.profile.LossDelay
+		if lossDelay <= 0 {
+			lossDelay = 200 * time.Millisecond
+		}
+		delay += lossDelay
+	}
+	if delay < 0 {
+		delay = 0
+	}
+	return delay
+}
+
+// waitN waits for n bytes worth of tokens, in chunks no bigger than the burst.
+func waitN(limiter *rate.Limiter, n int) {
+	for n > 0 {
+		chunk := n
+		if chunk > limiter.Burst() {
+			chunk = limiter.Burst()
+		}
+		_ = limiter.WaitN(context.Background(), chunk)
+		n -= chunk
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// parseArgs returns the config file and the --set overrides from the command
+// line arguments.
+func parseArgs(args []string, configFile string) (string, []string, error) {
+	var overrides []string
+	fileSet := false
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--set" || arg == "-set":
+			if i+1 == len(args) {
+				return "", nil, fmt.Errorf("%s requires Key=Value", arg)
+			}
+			i++
+			overrides = append(overrides, args[i])
+		case strings.HasPrefix(arg, "--set="):
+			overrides = append(overrides, strings.TrimPrefix(arg, "--set="))
+		case strings.HasPrefix(arg, "-"):
+			return "", nil, fmt.Errorf("unknown flag %s", arg)
+		case !fileSet:
+			configFile = arg
+			fileSet = true
+		default:
+			return "", nil, fmt.Errorf("unexpected argument %s", arg)
+		}
+	}
+	return configFile, overrides, nil
+}
+
+// applyOverrides sets the config fields given as Key.SubKey=Value, the value
+// being parsed as YAML, and returns the resulting config. Overriding the YAML
+// document rather than the config struct gives values the same syntax as the
+// config file, e.g. for durations and lists. The benchmark parameters are at
+// the top level of the config file, so a Params prefix is optional.
+func applyOverrides(configBytes []byte, overrides []string) ([]byte, error) {
+	if len(overrides) == 0 {
+		return configBytes, nil
+	}
+
+	doc := make(map[interface{}]interface{})
+	if err := yaml.Unmarshal(configBytes, &doc); err != nil {
+		return nil, err
+	}
+
+	for _, override := range overrides {
+		eq := strings.IndexByte(override, '=')
+		if eq <= 0 {
+			return nil, fmt.Errorf("override %q must be Key=Value", override)
+		}
+
+		keys := strings.Split(override[:eq], ".")
+		if keys[0] == "Params" && len(keys) > 1 {
+			keys = keys[1:]
+		}
+
+		var value interface{}
+		if err := yaml.Unmarshal([]byte(override[eq+1:]), &value); err != nil {
+			return nil, fmt.Errorf("override %q: %v", override, err)
+		}
+
+		node := doc
+		for _, key := range keys[:len(keys)-1] {
+			child, ok := node[key].(map[interface{}]interface{})
+			if !ok {
+				if node[key] != nil {
+					return nil, fmt.Errorf("override %q: %s is not a section", override, key)
+				}
+				child = make(map[interface{}]interface{})
+				node[key] = child
+			}
+			node = child
+		}
+		node[keys[len(keys)-1]] = value
+	}
+
+	return yaml.Marshal(doc)
+}
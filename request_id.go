@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"labench/bench"
+)
+
+// requestIDLog records the IDs of failed and slow requests, so they can be
+// found in the server logs.
+type requestIDLog struct {
+	mu         sync.Mutex
+	file       *os.File
+	writer     *bufio.Writer
+	minLatency time.Duration
+}
+
+// newRequestIDLog creates the log file. Successful requests are logged if
+// they took at least minLatency, unless it's zero.
+func newRequestIDLog(file string, minLatency time.Duration) (*requestIDLog, error) {
+	f, err := os.Create(file)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &requestIDLog{file: f, writer: bufio.NewWriter(f), minLatency: minLatency}
+	_, err = l.writer.WriteString("Time\tRequestID\tLatency\tResult\n")
+	return l, err
+}
+
+func (l *requestIDLog) log(id string, latency time.Duration, err error) {
+	result := "OK"
+	switch {
+	case errors.Is(err, bench.ErrSlow):
+		result = "Slow"
+	case err != nil:
+		result = err.Error()
+	case l.minLatency <= 0 || latency < l.minLatency:
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	fmt.Fprintf(l.writer, "%s\t%s\t%v\t%s\n", time.Now().UTC().Format(time.RFC3339Nano), id, latency, result)
+}
+
+// Close flushes and closes the log file.
+func (l *requestIDLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := l.writer.Flush(); err != nil {
+		return err
+	}
+	return l.file.Close()
+}
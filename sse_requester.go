@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"labench/bench"
+)
+
+// sseConfig selects what is measured on server-sent event streams.
+type sseConfig struct {
+	// Metric is either "first", the time from opening a stream to its Nth
+	// event, or "gap", the steady-state gap between consecutive events of a
+	// stream kept open by each connection.
+	Metric string `yaml:"Metric"`
+	// Event is the N of the "first" metric, defaults to 1.
+	Event int `yaml:"Event"`
+}
+
+func (c *sseConfig) validate() error {
+	if c.Metric == "" {
+		c.Metric = "first"
+	}
+	if c.Metric != "first" && c.Metric != "gap" {
+		return errors.New("SSE Metric must be first or gap")
+	}
+	if c.Event == 0 {
+		c.Event = 1
+	}
+	if c.Event < 0 {
+		return errors.New("SSE Event must be positive")
+	}
+	return nil
+}
+
+// sseRequesterFactory implements RequesterFactory by creating a Requester
+// which consumes server-sent event streams from the Request URL.
+type sseRequesterFactory struct {
+	web     *WebRequesterFactory
+	config  sseConfig
+	timeout time.Duration
+}
+
+// GetRequester returns a new Requester, called for each Benchmark connection.
+func (f *sseRequesterFactory) GetRequester(number uint64) bench.Requester {
+	web := f.web.GetRequester(number).(*webRequester)
+	return &sseRequester{web: web, config: f.config, timeout: f.timeout}
+}
+
+// sseEvent is the arrival of an event on a stream kept open by sseRequester,
+// or the error which ended the stream.
+type sseEvent struct {
+	gap time.Duration
+	err error
+}
+
+// sseRequester implements Requester by reading server-sent events. With the
+// "first" metric each request opens a stream and reads it up to the Nth
+// event, with the "gap" metric each request takes the gap before the next
+// event of a stream read in the background.
+type sseRequester struct {
+	web     *webRequester
+	config  sseConfig
+	timeout time.Duration
+
+	events  chan sseEvent
+	cancel  context.CancelFunc
+	lastGap time.Duration
+}
+
+// Setup prepares the Requester for benchmarking.
+func (s *sseRequester) Setup() error { return nil }
+
+// MeasuredLatency implements bench.LatencyMeasurer for the "gap" metric, as
+// the time spent waiting for the next event is not the gap itself.
+func (s *sseRequester) MeasuredLatency() (time.Duration, bool) {
+	return s.lastGap, s.config.Metric == "gap"
+}
+
+// Request performs a synchronous request to the system under test.
+func (s *sseRequester) Request() error {
+	if s.config.Metric == "gap" {
+		return s.nextGap()
+	}
+
+	ctx := context.Background()
+	if s.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.timeout)
+		defer cancel()
+	}
+
+	stream, err := s.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	reader := bufio.NewReader(stream)
+	for i := 0; i < s.config.Event; i++ {
+		if err := readSSEEvent(reader); err != nil {
+			if ctx.Err() == context.DeadlineExceeded {
+				return fmt.Errorf("SSE event %d not received within %v", i+1, s.timeout)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// nextGap waits for the next event of the stream, opening it if needed.
+func (s *sseRequester) nextGap() error {
+	s.lastGap = 0
+	if s.events == nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		stream, err := s.open(ctx)
+		if err != nil {
+			cancel()
+			return err
+		}
+		s.events = make(chan sseEvent, 1024)
+		s.cancel = cancel
+		go readSSEGaps(stream, s.events)
+	}
+
+	var timeout <-chan time.Time
+	if s.timeout > 0 {
+		timer := time.NewTimer(s.timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case event := <-s.events:
+		if event.err != nil {
+			s.close()
+			return event.err
+		}
+		s.lastGap = event.gap
+		return nil
+	case <-timeout:
+		s.close()
+		return fmt.Errorf("SSE event not received within %v", s.timeout)
+	}
+}
+
+// open sends the request and checks the response is an event stream.
+func (s *sseRequester) open(ctx context.Context) (io.ReadCloser, error) {
+	req, err := s.web.newRequest("")
+	if err != nil {
+		return nil, err
+	}
+
+	// The headers may be shared by all requesters
+	req.Header = req.Header.Clone()
+	if req.Header == nil {
+		req.Header = make(http.Header)
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("Cache-Control", "no-cache")
+
+	resp, err := httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != s.web.expectedReturnCode {
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("Expected %v got %v", s.web.expectedReturnCode, resp.StatusCode)
+	}
+
+	if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType != "text/event-stream" {
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("malformed SSE stream: Content-Type %q", resp.Header.Get("Content-Type"))
+	}
+
+	return resp.Body, nil
+}
+
+// close ends the stream kept open for the "gap" metric, the next request
+// opens a new one.
+func (s *sseRequester) close() {
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.events = nil
+	s.cancel = nil
+}
+
+// Teardown is called upon benchmark completion.
+func (s *sseRequester) Teardown() error {
+	s.close()
+	return nil
+}
+
+// readSSEGaps reads events until the stream fails, sending the time since the
+// previous event. The gap before the first event is the time to connect and
+// is skipped. Gaps are dropped when not consumed fast enough.
+func readSSEGaps(stream io.ReadCloser, events chan<- sseEvent) {
+	defer stream.Close()
+
+	reader := bufio.NewReader(stream)
+	var last time.Time
+	for {
+		err := readSSEEvent(reader)
+		if err != nil {
+			select {
+			case events <- sseEvent{err: err}:
+			default:
+			}
+			return
+		}
+
+		now := time.Now()
+		if !last.IsZero() {
+			select {
+			case events <- sseEvent{gap: now.Sub(last)}:
+			default:
+			}
+		}
+		last = now
+	}
+}
+
+// readSSEEvent reads lines up to the end of the next event carrying data, as
+// events without data are not dispatched.
+func readSSEEvent(reader *bufio.Reader) error {
+	hasData := false
+	for {
+		line, err := reader.ReadString('\n')
+		if err == io.EOF && line == "" {
+			if hasData {
+				return errors.New("malformed SSE stream: truncated event")
+			}
+			return errors.New("SSE stream closed")
+		}
+		if err != nil && err != io.EOF {
+			return err
+		}
+		line = strings.TrimRight(line, "\r\n")
+
+		if line == "" {
+			if hasData {
+				return nil
+			}
+			continue
+		}
+
+		// Comment, usually a keep-alive
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+
+		field, value := line, ""
+		if i := strings.IndexByte(line, ':'); i >= 0 {
+			field, value = line[:i], strings.TrimPrefix(line[i+1:], " ")
+		}
+
+		switch field {
+		case "data":
+			hasData = true
+		case "retry":
+			if _, err := strconv.ParseUint(value, 10, 64); err != nil {
+				return fmt.Errorf("malformed SSE stream: retry %q", value)
+			}
+		}
+
+		if err == io.EOF {
+			return errors.New("malformed SSE stream: truncated event")
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// sweepConfig lists the values of config fields to run the benchmark with,
+// either as the cartesian product of Parameters or as a list of Points.
+type sweepConfig struct {
+	Parameters yaml.MapSlice   `yaml:"Parameters"`
+	Points     []yaml.MapSlice `yaml:"Points"`
+	CSVFile    string          `yaml:"CSVFile"`
+}
+
+// sweepParam is a config field set for a sweep point, formatted both as an
+// override and for the CSV.
+type sweepParam struct {
+	key      string
+	value    string
+	override string
+}
+
+// points returns the config fields set for each run of the sweep.
+func (s *sweepConfig) points() ([][]sweepParam, error) {
+	if (len(s.Parameters) == 0) == (len(s.Points) == 0) {
+		return nil, errors.New("Sweep must have either Parameters or Points")
+	}
+
+	if len(s.Points) > 0 {
+		points := make([][]sweepParam, 0, len(s.Points))
+		var keys []string
+		for i, item := range s.Points {
+			point := make([]sweepParam, 0, len(item))
+			for j, field := range item {
+				param, err := newSweepParam(field.Key, field.Value)
+				if err != nil {
+					return nil, err
+				}
+				if i == 0 {
+					keys = append(keys, param.key)
+				} else if len(item) != len(keys) || keys[j] != param.key {
+					return nil, fmt.Errorf("Sweep point %d must set %s in this order", i+1, strings.Join(keys, ", "))
+				}
+				point = append(point, param)
+			}
+			points = append(points, point)
+		}
+		return points, nil
+	}
+
+	// Cartesian product, the last parameter varying fastest
+	points := [][]sweepParam{nil}
+	for _, field := range s.Parameters {
+		values, ok := field.Value.([]interface{})
+		if !ok || len(values) == 0 {
+			return nil, fmt.Errorf("Sweep parameter %v must be a list of values", field.Key)
+		}
+
+		product := make([][]sweepParam, 0, len(points)*len(values))
+		for _, point := range points {
+			for _, value := range values {
+				param, err := newSweepParam(field.Key, value)
+				if err != nil {
+					return nil, err
+				}
+				extended := append(append([]sweepParam(nil), point...), param)
+				product = append(product, extended)
+			}
+		}
+		points = product
+	}
+	return points, nil
+}
+
+func newSweepParam(key, value interface{}) (sweepParam, error) {
+	valueYAML, err := yaml.Marshal(value)
+	if err != nil {
+		return sweepParam{}, err
+	}
+	name := fmt.Sprint(key)
+	return sweepParam{name, fmt.Sprint(value), name + "=" + string(valueYAML)}, nil
+}
+
+// sweepOutFile returns the distribution file of the given sweep point, so
+// that points don't overwrite each other's.
+func sweepOutFile(outfile string, point int) string {
+	if outfile == "" {
+		outfile = "out/res.hgrm"
+	}
+	ext := path.Ext(outfile)
+	return fmt.Sprintf("%s.%d%s", strings.TrimSuffix(outfile, ext), point, ext)
+}
+
+// runSweep runs the benchmark for each point of the sweep and writes the key
+// metrics of all of them to a CSV file.
+func runSweep(configBytes []byte, sweep *sweepConfig) error {
+	points, err := sweep.points()
+	if err != nil {
+		return err
+	}
+
+	csvFile := sweep.CSVFile
+	if csvFile == "" {
+		csvFile = "out/sweep.csv"
+	}
+	if err := os.MkdirAll(path.Dir(csvFile), os.ModeDir|os.ModePerm); err != nil {
+		return err
+	}
+	file, err := os.Create(csvFile)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := csv.NewWriter(file)
+	var header []string
+	for _, param := range points[0] {
+		header = append(header, param.key)
+	}
+	header = append(header, "Throughput (req/sec)", "P50 (ms)", "P99 (ms)", "SuccessTotal", "ErrorTotal", "Success %")
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+
+	for i, point := range points {
+		overrides := make([]string, 0, len(point))
+		var row []string
+		for _, param := range point {
+			overrides = append(overrides, param.override)
+			row = append(row, param.value)
+		}
+		fmt.Printf("\nSweep point %d of %d: %s\n", i+1, len(points), strings.Join(row, ", "))
+
+		pointBytes, err := applyOverrides(configBytes, overrides)
+		if err != nil {
+			return err
+		}
+		var conf config
+		if err := yaml.Unmarshal(pointBytes, &conf); err != nil {
+			return err
+		}
+		conf.Output = sweepOutFile(conf.Output, i+1)
+
+		summary := runBenchmark(&conf)
+
+		var successRate float64
+		if total := summary.SuccessTotal + summary.ErrorTotal + summary.SlowTotal; total > 0 {
+			successRate = 100 * float64(summary.SuccessTotal) / float64(total)
+		}
+
+		row = append(row,
+			strconv.FormatFloat(summary.Throughput, 'f', 2, 64),
+			strconv.FormatFloat(float64(summary.SuccessHistogram.ValueAtQuantile(50))/1000000, 'f', 2, 64),
+			strconv.FormatFloat(float64(summary.SuccessHistogram.ValueAtQuantile(99))/1000000, 'f', 2, 64),
+			strconv.FormatUint(summary.SuccessTotal, 10),
+			strconv.FormatUint(summary.ErrorTotal, 10),
+			strconv.FormatFloat(successRate, 'f', 2, 64))
+		if err := writer.Write(row); err != nil {
+			return err
+		}
+		// Keep the results of completed points if a later one fails
+		writer.Flush()
+		if err := writer.Error(); err != nil {
+			return err
+		}
+	}
+
+	fmt.Println("\nSweep results written to", csvFile)
+	return nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// loadURLsFile appends the targets listed in URLsFile to URLs. Each line is
+// a URL, optionally followed by an HTTP method and a request body, separated
+// by whitespace; the body is the rest of the line. Empty lines and lines
+// starting with # are skipped. Environment variables are expanded per line.
+func (w *WebRequesterFactory) loadURLsFile() error {
+	f, err := os.Open(w.URLsFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	// Allow long lines, bodies can be large
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(os.ExpandEnv(scanner.Text()))
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		var target urlTarget
+		target.URL, line = cutField(line)
+		target.Method, target.Body = cutField(line)
+		if target.Method != "" && strings.ToUpper(target.Method) != target.Method {
+			return fmt.Errorf("%s:%d: invalid HTTP method %q", w.URLsFile, lineNumber, target.Method)
+		}
+		w.URLs = append(w.URLs, target)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	if len(w.URLs) == 0 {
+		return fmt.Errorf("no URLs found in %s", w.URLsFile)
+	}
+
+	return nil
+}
+
+// cutField splits s at the first run of whitespace.
+func cutField(s string) (string, string) {
+	i := strings.IndexAny(s, " \t")
+	if i < 0 {
+		return s, ""
+	}
+	return s[:i], strings.TrimLeft(s[i:], " \t")
+}
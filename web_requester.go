@@ -1,14 +1,17 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math/rand"
 	"net"
 	"net/http"
+	"net/http/httptrace"
 	"net/url"
 	"os"
 	"strings"
@@ -25,82 +28,186 @@ var (
 	httpClient    *http.Client
 	defaultDialer *net.Dialer
 	noLinger      bool
+	tcpNoDelay    = true
+	dialSemaphore chan struct{}
+	hostOverrides map[string]string
+	netProfile    *networkProfile
 )
 
-func noLingerDialer(ctx context.Context, network, addr string) (net.Conn, error) {
-	con, err := defaultDialer.DialContext(ctx, network, addr)
-	if err == nil && con != nil && noLinger {
-		maybePanic(con.(*net.TCPConn).SetLinger(0))
+// tuneConn applies socket options to a freshly dialed connection.
+// Go enables TCP_NODELAY on every new TCP connection right after connect,
+// so it can't be controlled from net.Dialer.Control and is applied here instead.
+func tuneConn(con net.Conn) {
+	tcpCon, ok := con.(*net.TCPConn)
+	if !ok {
+		return
+	}
+	if noLinger {
+		maybePanic(tcpCon.SetLinger(0))
+	}
+	if !tcpNoDelay {
+		maybePanic(tcpCon.SetNoDelay(false))
+	}
+}
+
+func dialConn(ctx context.Context, network, addr string) (net.Conn, error) {
+	// Bound the number of connections being established at once
+	if dialSemaphore != nil {
+		select {
+		case dialSemaphore <- struct{}{}:
+			defer func() { <-dialSemaphore }()
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	con, err := defaultDialer.DialContext(ctx, network, overrideHost(addr))
+	if err == nil && con != nil {
+		tuneConn(con)
+		if netProfile != nil {
+			con = netProfile.wrap(con)
+		}
 	}
 	return con, err
 }
 
-func initHTTPClient(reuseConnections bool, requestTimeout time.Duration, dontLinger bool) {
+// overrideHost returns the address to connect to according to HostOverrides,
+// which map either host or host:port to IP or IP:port. TLS SNI and the Host
+// header still use the original host, as only the connection is redirected.
+func overrideHost(addr string) string {
+	if override, ok := hostOverrides[addr]; ok {
+		return override
+	}
+
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+
+	override, ok := hostOverrides[host]
+	if !ok {
+		return addr
+	}
+	if _, _, err := net.SplitHostPort(override); err == nil {
+		return override
+	}
+	return net.JoinHostPort(override, port)
+}
+
+func initDialer(params *benchParams) {
 	defaultDialer = &net.Dialer{
-		Timeout: requestTimeout,
+		Timeout: params.RequestTimeout,
 		// Disable TCP keepalives as we are sending data very actively anyway.
 		// Should not be confused with HTTP keep alive.
 		KeepAlive: 0,
 	}
 
+	noLinger = params.DontLinger
+	tcpNoDelay = params.TCPNoDelay == nil || *params.TCPNoDelay
+
+	hostOverrides = params.HostOverrides
+	netProfile = params.NetworkProfile
+
+	if params.MaxConcurrentDials > 0 {
+		dialSemaphore = make(chan struct{}, params.MaxConcurrentDials)
+	}
+}
+
+func initHTTPClient(params *benchParams) {
+	initDialer(params)
+
 	httpClient = &http.Client{
 		Transport: &http.Transport{
 			Proxy:                 http.ProxyFromEnvironment,
-			DialContext:           noLingerDialer,
-			DisableKeepAlives:     !reuseConnections,
+			DialContext:           dialConn,
+			DisableKeepAlives:     !params.ReuseConnections,
 			MaxIdleConns:          0,
 			MaxIdleConnsPerHost:   0,
 			IdleConnTimeout:       90 * time.Second,
-			ResponseHeaderTimeout: requestTimeout,
-			TLSHandshakeTimeout:   requestTimeout,
+			ResponseHeaderTimeout: params.RequestTimeout,
+			TLSHandshakeTimeout:   params.RequestTimeout,
 			ExpectContinueTimeout: 1 * time.Second,
 		},
-		Timeout: requestTimeout}
-
-	noLinger = dontLinger
+		Timeout: params.RequestTimeout}
 }
 
-func initHTTP2Client(requestTimeout time.Duration, dontLinger bool) {
-	defaultDialer = &net.Dialer{
-		Timeout: requestTimeout,
-		// Disable TCP keepalives as we are sending data very actively anyway.
-		// Should not be confused with HTTP keep alive.
-		KeepAlive: 0,
-	}
+func initHTTP2Client(params *benchParams) {
+	initDialer(params)
 
 	httpClient = &http.Client{
 		Transport: &http2.Transport{
 			AllowHTTP: true,
 			DialTLS: func(network, addr string, cfg *tls.Config) (net.Conn, error) {
-				con, err := defaultDialer.Dial(network, addr)
-				if err == nil && con != nil && noLinger {
-					maybePanic(con.(*net.TCPConn).SetLinger(0))
-				}
-				return con, err
+				return dialConn(context.Background(), network, addr)
 			},
 		},
-		Timeout: requestTimeout}
+		Timeout: params.RequestTimeout}
+}
+
+// urlTarget is an entry of URLs: either a plain URL, or a map with URL and
+// an optional Host header, HTTP method and body used for requests to this URL.
+type urlTarget struct {
+	URL    string `yaml:"URL"`
+	Host   string `yaml:"Host"`
+	Method string `yaml:"Method"`
+	Body   string `yaml:"Body"`
+}
+
+// UnmarshalYAML accepts both a plain URL and a map.
+func (t *urlTarget) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	if err := unmarshal(&t.URL); err == nil {
+		return nil
+	}
+
+	type plain urlTarget
+	return unmarshal((*plain)(t))
+}
+
+// hostTarget is an entry of Hosts: either a plain host[:port], or a map with
+// Address (host[:port]) and an optional Host header sent to this address.
+type hostTarget struct {
+	Address string `yaml:"Address"`
+	Host    string `yaml:"Host"`
+}
+
+// UnmarshalYAML accepts both a plain host[:port] and a map.
+func (t *hostTarget) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	if err := unmarshal(&t.Address); err == nil {
+		return nil
+	}
 
-	noLinger = dontLinger
+	type plain hostTarget
+	return unmarshal((*plain)(t))
 }
 
 // WebRequesterFactory implements RequesterFactory by creating a Requester
 // which makes GET requests to the provided URL.
 type WebRequesterFactory struct {
-	URL                    string            `yaml:"URL"`
-	URLs                   []string          `yaml:"URLs"`
-	Hosts                  []string          `yaml:"Hosts"`
-	Headers                map[string]string `yaml:"Headers"`
-	Body                   string            `yaml:"Body"`
-	BodyFile               string            `yaml:"BodyFile"`
-	ExpectedHTTPStatusCode int               `yaml:"ExpectedHTTPStatusCode"`
-	HTTPMethod             string            `yaml:"HTTPMethod"`
+	URL                    string                `yaml:"URL"`
+	URLs                   []urlTarget           `yaml:"URLs"`
+	URLsFile               string                `yaml:"URLsFile"`
+	Hosts                  []hostTarget          `yaml:"Hosts"`
+	Headers                map[string]string     `yaml:"Headers"`
+	Body                   string                `yaml:"Body"`
+	BodyFile               string                `yaml:"BodyFile"`
+	BodySchema             string                `yaml:"BodySchema"`
+	BodyGenerator          []bodyField           `yaml:"BodyGenerator"`
+	BodySizeDistribution   *bodySizeDistribution `yaml:"BodySizeDistribution"`
+	ExpectedHTTPStatusCode int                   `yaml:"ExpectedHTTPStatusCode"`
+	HTTPMethod             string                `yaml:"HTTPMethod"`
+	AuthTokenCommand       *authTokenCommand     `yaml:"AuthTokenCommand"`
+	RequestIDHeader        string                `yaml:"RequestIDHeader"`
+	RequestIDLog           string                `yaml:"RequestIDLog"`
+	RequestIDLogLatency    time.Duration         `yaml:"RequestIDLogLatency"`
 
 	expandedHeaders map[string][]string
+	slowThreshold   time.Duration
+	requestIDLog    *requestIDLog
+	ttfb            bool
 }
 
 // GetRequester returns a new Requester, called for each Benchmark connection.
-func (w *WebRequesterFactory) GetRequester(uint64) bench.Requester {
+func (w *WebRequesterFactory) GetRequester(number uint64) bench.Requester {
 	// if len(w.expandedHeaders) != len(w.Headers) {
 	if w.expandedHeaders == nil {
 		expandedHeaders := make(map[string][]string)
@@ -117,19 +224,37 @@ func (w *WebRequesterFactory) GetRequester(uint64) bench.Requester {
 		w.Body = string(content)
 	}
 
-	return &webRequester{w.URL, w.URLs, w.Hosts, w.expandedHeaders, w.Body, w.ExpectedHTTPStatusCode, w.HTTPMethod}
+	var rng *rand.Rand
+	if w.BodyGenerator != nil || w.BodySizeDistribution != nil || w.RequestIDHeader != "" {
+		rng = rand.New(rand.NewSource(time.Now().UnixNano() + int64(number)))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &webRequester{w.URL, w.URLs, w.Hosts, w.expandedHeaders, w.Body, w.ExpectedHTTPStatusCode, w.HTTPMethod, w.BodyGenerator, rng, w.slowThreshold, w.AuthTokenCommand, w.RequestIDHeader, w.requestIDLog, w.BodySizeDistribution, 0, w.ttfb, 0, ctx, cancel}
 }
 
 // webRequester implements Requester by making a GET request to the provided
 // URL.
 type webRequester struct {
 	url                string
-	urls               []string
-	hosts              []string
+	urls               []urlTarget
+	hosts              []hostTarget
 	headers            map[string][]string
 	body               string
 	expectedReturnCode int
 	httpMethod         string
+	bodyGenerator      []bodyField
+	rng                *rand.Rand
+	slowThreshold      time.Duration
+	authToken          *authTokenCommand
+	requestIDHeader    string
+	requestIDLog       *requestIDLog
+	bodySizes          *bodySizeDistribution
+	lastBodySize       int64
+	ttfb               bool
+	lastTTFB           time.Duration
+	ctx                context.Context // of every request, canceled by CancelRequest
+	cancel             context.CancelFunc
 }
 
 var nextHostOrURL int32 = -1
@@ -137,30 +262,92 @@ var nextHostOrURL int32 = -1
 // Setup prepares the Requester for benchmarking.
 func (w *webRequester) Setup() error { return nil }
 
+// MeasuredLatency implements bench.LatencyMeasurer when the latency metric is
+// time to first byte.
+func (w *webRequester) MeasuredLatency() (time.Duration, bool) {
+	return w.lastTTFB, w.ttfb && w.lastTTFB > 0
+}
+
+// RequestSize implements bench.RequestSizer when BodySizeDistribution is used.
+func (w *webRequester) RequestSize() (int64, bool) {
+	return w.lastBodySize, w.bodySizes != nil
+}
+
 // Request performs a synchronous request to the system under test.
 func (w *webRequester) Request() error {
-	var reqURL string
+	if w.requestIDHeader == "" {
+		return w.request("")
+	}
+
+	id := newUUID(w.rng)
+	if w.requestIDLog == nil {
+		return w.request(id)
+	}
+
+	start := time.Now()
+	err := w.request(id)
+	w.requestIDLog.log(id, time.Since(start), err)
+	return err
+}
+
+// newRequest builds the next request with the given ID, if not empty.
+func (w *webRequester) newRequest(requestID string) (*http.Request, error) {
+	body := w.body
+	if w.bodyGenerator != nil {
+		body = generateBody(w.rng, w.bodyGenerator)
+	}
+
+	method := w.httpMethod
+	var reqURL, targetHost string
 	if w.urls != nil {
 		h := atomic.AddInt32(&nextHostOrURL, 1)
-		reqURL = w.urls[h%int32(len(w.urls))]
+		target := w.urls[h%int32(len(w.urls))]
+		reqURL, targetHost = target.URL, target.Host
+		if target.Method != "" {
+			method = target.Method
+		}
+		if target.Body != "" {
+			body = target.Body
+		}
 	} else if w.hosts != nil {
 		parsedURL, err := url.Parse(w.url)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		h := atomic.AddInt32(&nextHostOrURL, 1)
-		parsedURL.Host = w.hosts[h%int32(len(w.hosts))]
-		reqURL = parsedURL.String()
+		target := w.hosts[h%int32(len(w.hosts))]
+		parsedURL.Host = target.Address
+		reqURL, targetHost = parsedURL.String(), target.Host
 	} else {
 		reqURL = w.url
 	}
 
-	req, err := http.NewRequest(w.httpMethod, reqURL, strings.NewReader(w.body))
+	var bodyReader io.Reader = strings.NewReader(body)
+	if w.bodySizes != nil {
+		sizedBody := w.bodySizes.body(w.rng)
+		w.lastBodySize = int64(len(sizedBody))
+		bodyReader = bytes.NewReader(sizedBody)
+	}
+
+	req, err := http.NewRequestWithContext(w.ctx, method, reqURL, bodyReader)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	req.Header = w.headers
+	if w.authToken != nil || requestID != "" {
+		// The headers are shared by all requesters, the token and ID change
+		req.Header = make(http.Header, len(w.headers)+2)
+		for key, val := range w.headers {
+			req.Header[key] = val
+		}
+		if w.authToken != nil {
+			req.Header.Set("Authorization", w.authToken.header())
+		}
+		if requestID != "" {
+			req.Header.Set(w.requestIDHeader, requestID)
+		}
+	}
 
 	// from https://golang.org/src/net/http/request.go?#L124
 	// For client requests, the URL's Host specifies the server to
@@ -171,16 +358,48 @@ func (w *webRequester) Request() error {
 	//case insensitive
 	if host, ok := w.headers["host"]; ok {
 		if len(host) != 1 {
-			return errors.New("multiple host headers are not allowed")
+			return nil, errors.New("multiple host headers are not allowed")
 		}
 		req.Host = host[0]
 	} else if host, ok = w.headers["Host"]; ok {
 		if len(host) != 1 {
-			return errors.New("multiple host headers are not allowed")
+			return nil, errors.New("multiple host headers are not allowed")
 		}
 		req.Host = host[0]
 	}
 
+	// Host of the rotated target takes precedence over the Host header
+	if targetHost != "" {
+		req.Host = targetHost
+	}
+
+	return req, nil
+}
+
+// request sends the request with the given ID, if not empty.
+func (w *webRequester) request(requestID string) error {
+	req, err := w.newRequest(requestID)
+	if err != nil {
+		return err
+	}
+
+	// Give up on requests exceeding the slow threshold, like impatient clients do
+	ctx := req.Context()
+	if w.slowThreshold > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, w.slowThreshold)
+		defer cancel()
+		req = req.WithContext(ctx)
+	}
+
+	w.lastTTFB = 0
+	if w.ttfb {
+		start := time.Now()
+		req = req.WithContext(httptrace.WithClientTrace(req.Context(), &httptrace.ClientTrace{
+			GotFirstResponseByte: func() { w.lastTTFB = time.Since(start) },
+		}))
+	}
+
 	resp, err := httpClient.Do(req)
 
 	/* to look at the response body
@@ -196,6 +415,10 @@ func (w *webRequester) Request() error {
 		_ = resp.Body.Close()
 	}
 
+	if ctx.Err() == context.DeadlineExceeded {
+		return bench.ErrSlow
+	}
+
 	if err != nil {
 		return err
 	}
@@ -211,5 +434,14 @@ func (w *webRequester) Request() error {
 	return nil
 }
 
+// CancelRequest implements bench.RequestCanceler, the requester can't send
+// any request after it.
+func (w *webRequester) CancelRequest() {
+	w.cancel()
+}
+
 // Teardown is called upon benchmark completion.
-func (w *webRequester) Teardown() error { return nil }
+func (w *webRequester) Teardown() error {
+	w.cancel()
+	return nil
+}